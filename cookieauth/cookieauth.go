@@ -0,0 +1,1166 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cookieauth writes cookie files for googlesource.com /
+// source.developers.google.com based on the gitconfig configs.
+package cookieauth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/credentials"
+	"golang.org/x/net/idna"
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/option"
+)
+
+const (
+	// FormatNetscape is the Netscape cookie file format.
+	FormatNetscape = "netscape"
+	// FormatJSON is a JSON array of cookies.
+	FormatJSON = "json"
+	// FormatCurl is the Netscape cookie file format as written by curl.
+	FormatCurl = "curl"
+	// FormatNetrc is the .netrc format with the tokens as the passwords.
+	FormatNetrc = "netrc"
+	// FormatHeader is a "Cookie:" request header line for a single host.
+	FormatHeader = "header"
+	// FormatCookieJar is a JSON array of net/http.Cookie, which can be
+	// unmarshaled to []*http.Cookie and passed to a net/http/cookiejar.Jar.
+	FormatCookieJar = "cookiejar"
+	// FormatHgrc is an hgrc [auth] section for Mercurial with the tokens as
+	// the passwords.
+	FormatHgrc = "hgrc"
+
+	// DefaultUsername is the username used with the tokens unless Username is
+	// set.
+	DefaultUsername = "git-service-account"
+
+	defaultConcurrency = 4
+)
+
+// Options is the options for WriteCookies.
+type Options struct {
+	// Configs are the additional Git configs passed to git via "-c".
+	Configs []string
+
+	// Path to a git-config file that git reads instead of the global one
+	// (~/.gitconfig). Configs take precedence over it. See
+	// credentials.GitBinary.ConfigFile.
+	GitConfigFile string
+
+	// Path to the git binary. If empty, git is searched in the PATH.
+	GitBinaryPath string
+
+	// If true, the cookies are written for the URLs other than https, such
+	// as http for a local test server, without the Secure attribute. By
+	// default, such URLs are skipped.
+	AllowInsecure bool
+
+	// Timeout for each git invocation. If zero, there's no timeout.
+	GitTimeout time.Duration
+
+	// Additional hosts to write cookies for, in addition to the URLs in
+	// git-config.
+	Hosts []string
+
+	// If non-empty, only the URLs whose hosts are in this list are written.
+	// The hosts not found in git-config, Hosts, or the default hosts are
+	// logged and ignored. Combine with Merge to keep the cookies for the
+	// other hosts.
+	OnlyHosts []string
+
+	// If true, the hosts of the submodule URLs in .gitmodules of the
+	// repository in the current directory are added.
+	IncludeSubmodules bool
+
+	// If true, the cookies for the *.googlesource.com hosts are written for
+	// .googlesource.com instead, so that they're sent to all the
+	// subdomains. This broadens the scope of the tokens.
+	WildcardGoogleSource bool
+
+	// Path of the cookies for source.developers.google.com without a path
+	// in git-config. If empty, it defaults to
+	// credentials.SourceDevelopersCookiePath.
+	SourceDevelopersCookiePath string
+
+	// Hosts not to write the cookies for this time, such as the ones that
+	// keep failing. They're the hosts as in Result.Hosts and HostError.
+	SkipHosts []string
+
+	// If true, googlesource.com and source.developers.google.com are not
+	// added unless they are in git-config or Hosts.
+	NoDefaultHosts bool
+
+	// If true, WriteCookies fails with ErrNoConfiguredHost unless a
+	// googlesource.com or source.developers.google.com host is in git-config,
+	// or Hosts is set. The default hosts are not added.
+	RequireConfiguredHost bool
+
+	// Path to the output file. "-" writes to stdout, and "&N" writes to the
+	// inherited file descriptor N. If empty, it's taken
+	// from ${GOOGLESOURCE_COOKIE_FILE}, google.cookieFile in git-config, or
+	// the default location in this order. For FormatHeader, it defaults to
+	// stdout instead.
+	OutputPath string
+
+	// Additional paths to write the same output to, in the same way as
+	// OutputPath. The tokens are created once for all of them. MinAge
+	// looks only at the main output file.
+	ExtraOutputPaths []string
+
+	// Permission mode of the output file. If zero, it defaults to 0600. The
+	// execute bits are not allowed.
+	FileMode os.FileMode
+
+	// Permission mode of the output directory if it's created. If zero, it
+	// defaults to 0700.
+	DirMode os.FileMode
+
+	// Output format. FormatNetscape, FormatCurl, FormatNetrc, FormatJSON,
+	// FormatHeader, FormatCookieJar, or FormatHgrc. If empty, it defaults to
+	// FormatNetscape. FormatHeader needs exactly one host. See
+	// ErrMultipleHosts.
+	Format string
+
+	// If true, the cookies in the existing output file are kept unless they
+	// are for the hosts whose cookies are written in this run. This is
+	// supported only for FormatNetscape, FormatCurl, FormatNetrc, and
+	// FormatHgrc. For FormatNetrc and FormatHgrc, the entries for the other
+	// hosts are kept.
+	Merge bool
+
+	// If true, the comment header with the creation time is not written for
+	// FormatNetscape and FormatCurl, so that the file changes only when the
+	// cookies change.
+	NoHeader bool
+
+	// If true, the written file is parsed before replacing the existing one
+	// to check that it has all the cookies. This is only for FormatNetscape
+	// and FormatCurl, and is skipped for stdout and file descriptors.
+	VerifyOutput bool
+
+	// If true, the cookies are appended to the output file after a comment
+	// with the time instead of replacing the file, so that the file keeps
+	// the history of the refreshes. This is for debugging and is supported
+	// only for FormatNetscape and FormatCurl. The file grows without limit.
+	Append bool
+
+	// Name of the cookies. If empty, it defaults to
+	// credentials.DefaultCookieName, which Gerrit expects. It must be a
+	// valid cookie name.
+	CookieName string
+
+	// Username used with the tokens in FormatNetrc, FormatHgrc, and the
+	// credential helper. If empty, it defaults to DefaultUsername. The cookies
+	// have no username.
+	Username string
+
+	// Maximum number of tokens created in parallel. If zero, it defaults to
+	// 4.
+	Concurrency int
+
+	// OAuth2 scopes. If non-empty, this overrides google.scopes in
+	// git-config. Changing the scopes may require a re-consent.
+	Scopes []string
+
+	// OAuth2 scopes for the individual hosts, keyed by the host such as
+	// "gerrit.example.com". They're used instead of Scopes for the hosts.
+	// This cannot be used with TokenSource, URLTokenSource, or
+	// IDTokenAudience.
+	HostScopes map[string][]string
+
+	// If non-empty, it's used instead of google.account in git-config for
+	// all hosts, such as to pick one of the accounts logged in to gcloud. It
+	// takes the same values as google.account. This cannot be used with the
+	// credentials that don't come from git-config, such as UseADC.
+	Account string
+
+	// If true, the tokens are created from the application default
+	// credentials instead of the credentials configured in git-config.
+	UseADC bool
+
+	// Path to a service account JSON key. If non-empty, the tokens are
+	// created from this key instead of the credentials configured in
+	// git-config. This cannot be used with UseADC.
+	KeyFile string
+
+	// Email of the service account to impersonate. If non-empty, the tokens
+	// are created for this service account with the credentials from
+	// UseADC, KeyFile, or the application default credentials if neither is
+	// set.
+	ImpersonateServiceAccount string
+
+	// Emails of the service accounts in the delegation chain for
+	// ImpersonateServiceAccount.
+	ImpersonateDelegates []string
+
+	// If non-empty, ID tokens for this audience are used instead of access
+	// tokens for all hosts. They're created from UseADC or KeyFile, and the
+	// credentials need to be a service account.
+	IDTokenAudience string
+
+	// If non-empty, the tokens for UseADC and KeyFile are created at this
+	// token endpoint instead of Google's, such as a local fake for testing
+	// or a token broker that mimics it.
+	TokenURL string
+
+	// Project to bill the token requests to. This applies only to UseADC,
+	// KeyFile, and ImpersonateServiceAccount, not to the credentials
+	// configured in git-config.
+	QuotaProject string
+
+	// If non-nil, all tokens are taken from this TokenSource instead of the
+	// credentials configured in git-config. This cannot be used with UseADC,
+	// KeyFile, or ImpersonateServiceAccount.
+	TokenSource oauth2.TokenSource
+
+	// SOCKS5 proxy for the token requests and the verification requests, as
+	// "host:port" or "socks5://[user:password@]host:port". If set, it's used
+	// instead of the proxy in ${HTTPS_PROXY}. The credentials configured in
+	// git-config that run gcloud are not affected.
+	SOCKS5Proxy string
+
+	// If non-nil, the token for each host is taken from this instead of the
+	// credentials configured in git-config. Use this to plug in a custom
+	// token store. This cannot be used with TokenSource or the other
+	// credential options.
+	URLTokenSource credentials.TokenSource
+
+	// Maximum number of retries for creating a token for each host. Only
+	// the transient errors, such as 429, 5xx, and network timeouts, are
+	// retried.
+	MaxRetries int
+
+	// If true, each token is verified by making an authenticated request to
+	// the host. The cookies are not written for the hosts that reject the
+	// token.
+	Verify bool
+
+	// Timeout for each verification request. If zero, it defaults to 10
+	// seconds.
+	VerifyTimeout time.Duration
+
+	// If positive, WriteCookies does nothing if the output file was modified
+	// within this duration. This is ignored when writing to stdout or a file
+	// descriptor. The returned expiry is zero in that case.
+	MinAge time.Duration
+
+	// If true, WriteCookies doesn't check that some credentials are
+	// available before creating the tokens. See ErrNoCredentials.
+	Force bool
+
+	// If true, the tokens are created and the cookies are logged without
+	// writing the output file.
+	DryRun bool
+
+	// If true, each step is logged.
+	Verbose bool
+
+	// Logger for the dry run and verbose logs. If nil, it logs to stderr.
+	Logger *log.Logger
+}
+
+// Validate checks the options.
+func (o *Options) Validate() error {
+	switch o.Format {
+	case "", FormatNetscape, FormatJSON, FormatCurl, FormatNetrc, FormatHeader, FormatCookieJar, FormatHgrc:
+	default:
+		return xerrors.Errorf("cookieauth: unknown format: %s", o.Format)
+	}
+	if o.Merge && (o.Format == FormatJSON || o.Format == FormatHeader || o.Format == FormatCookieJar) {
+		return xerrors.Errorf("cookieauth: merge is not supported for %s", o.Format)
+	}
+	if o.Append && (o.Merge || o.Format != "" && o.Format != FormatNetscape && o.Format != FormatCurl) {
+		return xerrors.Errorf("cookieauth: append is supported only for %s and %s without merge", FormatNetscape, FormatCurl)
+	}
+	if o.UseADC && o.KeyFile != "" {
+		return xerrors.Errorf("cookieauth: the application default credentials and a key file cannot be used together")
+	}
+	if o.TokenSource != nil && (o.UseADC || o.KeyFile != "" || o.ImpersonateServiceAccount != "") {
+		return xerrors.Errorf("cookieauth: a TokenSource cannot be used with the other credential options")
+	}
+	if len(o.HostScopes) > 0 && (o.TokenSource != nil || o.URLTokenSource != nil || o.IDTokenAudience != "") {
+		return xerrors.Errorf("cookieauth: HostScopes cannot be used with a TokenSource, a URLTokenSource, or an ID token audience")
+	}
+	for h, scopes := range o.HostScopes {
+		if n, err := NormalizeHost(h); err != nil {
+			return err
+		} else if n != h {
+			return xerrors.Errorf("cookieauth: the host %s in HostScopes is not normalized. Use %s", h, n)
+		}
+		if len(scopes) == 0 {
+			return xerrors.Errorf("cookieauth: no scopes for %s in HostScopes", h)
+		}
+	}
+	if o.SOCKS5Proxy != "" {
+		if _, err := parseSOCKS5Proxy(o.SOCKS5Proxy); err != nil {
+			return err
+		}
+	}
+	if o.URLTokenSource != nil && (o.TokenSource != nil || o.UseADC || o.KeyFile != "" || o.ImpersonateServiceAccount != "") {
+		return xerrors.Errorf("cookieauth: a URLTokenSource cannot be used with the other credential options")
+	}
+	if o.Format == FormatNetrc && o.FileMode&0077 != 0 {
+		return xerrors.Errorf("cookieauth: .netrc must not be accessible by others: %#o", uint32(o.FileMode))
+	}
+	if o.Format == FormatHgrc && o.FileMode&0077 != 0 {
+		return xerrors.Errorf("cookieauth: hgrc must not be accessible by others: %#o", uint32(o.FileMode))
+	}
+	if o.CookieName != "" && !credentials.ValidCookieName(o.CookieName) {
+		return xerrors.Errorf("cookieauth: invalid cookie name: %q", o.CookieName)
+	}
+	if o.FileMode&^os.ModePerm != 0 || o.FileMode&0111 != 0 {
+		return xerrors.Errorf("cookieauth: invalid file mode: %#o", uint32(o.FileMode))
+	}
+	if o.DirMode&^os.ModePerm != 0 || (o.DirMode != 0 && o.DirMode&0700 != 0700) {
+		return xerrors.Errorf("cookieauth: invalid directory mode: %#o. The owner needs rwx", uint32(o.DirMode))
+	}
+	if o.MinAge < 0 {
+		return xerrors.Errorf("cookieauth: negative min age: %v", o.MinAge)
+	}
+	if o.QuotaProject != "" && !o.UseADC && o.KeyFile == "" && o.ImpersonateServiceAccount == "" {
+		return xerrors.Errorf("cookieauth: a quota project needs the application default credentials, a key file, or impersonation")
+	}
+	if o.IDTokenAudience != "" && (!o.UseADC && o.KeyFile == "" || o.ImpersonateServiceAccount != "" || o.QuotaProject != "") {
+		return xerrors.Errorf("cookieauth: an ID token audience needs the application default credentials or a key file, and cannot be used with impersonation or a quota project")
+	}
+	if o.Account != "" && (o.hasSharedCredentials() || o.URLTokenSource != nil || o.IDTokenAudience != "") {
+		return xerrors.Errorf("cookieauth: an account cannot be used with the credentials that don't come from git-config")
+	}
+	if o.TokenURL != "" {
+		if !o.UseADC && o.KeyFile == "" || o.IDTokenAudience != "" {
+			return xerrors.Errorf("cookieauth: a token URL needs the application default credentials or a key file, and cannot be used with an ID token audience")
+		}
+		if u, err := url.Parse(o.TokenURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return xerrors.Errorf("cookieauth: invalid token URL: %s", o.TokenURL)
+		}
+	}
+	if len(o.ImpersonateDelegates) > 0 && o.ImpersonateServiceAccount == "" {
+		return xerrors.Errorf("cookieauth: delegates are specified without a service account to impersonate")
+	}
+	if o.SourceDevelopersCookiePath != "" && !strings.HasPrefix(o.SourceDevelopersCookiePath, "/") {
+		return xerrors.Errorf("cookieauth: the cookie path for source.developers.google.com must start with \"/\": %s", o.SourceDevelopersCookiePath)
+	}
+	if len(o.ExtraOutputPaths) > 0 {
+		seen := map[string]bool{o.OutputPath: true}
+		for _, p := range o.ExtraOutputPaths {
+			if p == "" {
+				return xerrors.Errorf("cookieauth: empty extra output path")
+			}
+			if seen[p] {
+				return xerrors.Errorf("cookieauth: duplicate output path: %s", p)
+			}
+			seen[p] = true
+		}
+	}
+	if _, err := ParseHosts(o.Hosts); err != nil {
+		return err
+	}
+	if _, err := ParseHosts(o.OnlyHosts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GitBinary returns the git binary specified by GitBinaryPath, or the one in
+// the PATH. Configs and GitConfigFile are set to the returned GitBinary.
+func (o *Options) GitBinary() (credentials.GitBinary, error) {
+	configFile, err := o.gitConfigFile()
+	if err != nil {
+		return credentials.GitBinary{}, err
+	}
+	if o.GitBinaryPath == "" {
+		g, err := credentials.FindGitBinary()
+		if err != nil {
+			return credentials.GitBinary{}, xerrors.Errorf("cookieauth: cannot find the git binary: %v", err)
+		}
+		g.Configs = o.Configs
+		g.ConfigFile = configFile
+		g.Timeout = o.GitTimeout
+		return g, nil
+	}
+	fi, err := os.Stat(o.GitBinaryPath)
+	if err != nil {
+		return credentials.GitBinary{}, xerrors.Errorf("cookieauth: cannot find the git binary: %v", err)
+	}
+	if !fi.Mode().IsRegular() || (runtime.GOOS != "windows" && fi.Mode()&0111 == 0) {
+		return credentials.GitBinary{}, xerrors.Errorf("cookieauth: %s is not an executable file", o.GitBinaryPath)
+	}
+	return credentials.GitBinary{Path: o.GitBinaryPath, Configs: o.Configs, ConfigFile: configFile, Timeout: o.GitTimeout}, nil
+}
+
+// gitConfigFile returns the absolute path to GitConfigFile. Git ignores a
+// missing file, so it's checked here.
+func (o *Options) gitConfigFile() (string, error) {
+	if o.GitConfigFile == "" {
+		return "", nil
+	}
+	fi, err := os.Stat(o.GitConfigFile)
+	if err != nil {
+		return "", xerrors.Errorf("cookieauth: cannot read the git-config file: %v", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", xerrors.Errorf("cookieauth: %s is not a regular file", o.GitConfigFile)
+	}
+	// A relative include.path is not allowed in "-c".
+	abs, err := filepath.Abs(o.GitConfigFile)
+	if err != nil {
+		return "", xerrors.Errorf("cookieauth: cannot get the absolute path to %s: %v", o.GitConfigFile, err)
+	}
+	return abs, nil
+}
+
+func (o *Options) cookieName() string {
+	if o.CookieName == "" {
+		return credentials.DefaultCookieName
+	}
+	return o.CookieName
+}
+
+func (o *Options) username() string {
+	if o.Username == "" {
+		return DefaultUsername
+	}
+	return o.Username
+}
+
+func (o *Options) logf(format string, v ...interface{}) {
+	if o.Logger != nil {
+		o.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// verbosef logs the message only if Verbose is set. Never pass secrets.
+func (o *Options) verbosef(format string, v ...interface{}) {
+	if o.Verbose {
+		o.logf(format, v...)
+	}
+}
+
+// Result is the summary of WriteCookies.
+type Result struct {
+	// The earliest expiry of the tokens used. This is zero if none of the
+	// tokens has an expiry.
+	Expiry time.Time
+
+	// Number of the cookies written.
+	Cookies int
+
+	// Hosts that the cookies were written for, sorted.
+	Hosts []string
+
+	// Expiry of the token for each of Hosts. The hosts whose tokens have
+	// no expiry are not included.
+	HostExpiries map[string]time.Time
+
+	// True if nothing was written because of MinAge.
+	Skipped bool
+}
+
+// String returns a summary such as "4 cookies for 2 hosts".
+func (r *Result) String() string {
+	if r.Skipped {
+		return "no cookies (skipped)"
+	}
+	return fmt.Sprintf("%d cookies for %d hosts", r.Cookies, len(r.Hosts))
+}
+
+// ListURLs returns the URLs that WriteCookies writes the cookies for, without
+// creating the tokens. They're the URLs in git-config, the submodule URLs if
+// IncludeSubmodules is set, Hosts, and the default hosts, after the URL
+// rewrites and the normalization, and filtered by OnlyHosts.
+func ListURLs(ctx context.Context, opts *Options) ([]*url.URL, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	hostURLs, err := ParseHosts(opts.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	gitBinary, err := opts.GitBinary()
+	if err != nil {
+		return nil, categorize(CategoryGit, err)
+	}
+	gitConfig, err := gitBinary.ConfigAll(ctx)
+	if err != nil {
+		return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot read git-config: %v", err))
+	}
+	return opts.listURLs(ctx, gitBinary, gitConfig, hostURLs)
+}
+
+// listURLs returns the URLs to write the cookies for. See ListURLs.
+func (o *Options) listURLs(ctx context.Context, gitBinary credentials.GitBinary, gitConfig *credentials.GitConfig, hostURLs []*url.URL) ([]*url.URL, error) {
+	urls, err := gitConfig.URLs()
+	if err != nil {
+		return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot read the list of URLs in git-config: %v", err))
+	}
+	o.verbosef("Found %d URLs in git-config: %v", len(urls), urls)
+	if o.IncludeSubmodules {
+		subURLs, err := gitBinary.ListSubmoduleURLs(ctx)
+		if err != nil {
+			return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot read the submodule URLs: %v", err))
+		}
+		o.verbosef("Found %d submodule URLs: %v", len(subURLs), subURLs)
+		for _, u := range subURLs {
+			if !containsRootURL(urls, u.Host) {
+				urls = append(urls, &url.URL{Scheme: u.Scheme, Host: u.Host})
+			}
+		}
+	}
+	urls, err = rewriteURLs(gitConfig.URLRewriter(), urls)
+	if err != nil {
+		return nil, categorize(CategoryConfig, err)
+	}
+	for _, u := range urls {
+		if u.Host, err = NormalizeHost(u.Host); err != nil {
+			return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: invalid URL %s in git-config: %v", u, err))
+		}
+	}
+	urls = o.filterInsecureURLs(urls)
+	// The submodule URLs and the rewrites change the order. Sort them so
+	// that the same cookies win on de-duplication.
+	sort.Slice(urls, func(i, j int) bool { return urls[i].String() < urls[j].String() })
+	for _, h := range hostURLs {
+		if !containsRootURL(urls, h.Host) {
+			urls = append(urls, h)
+		}
+	}
+	if o.RequireConfiguredHost {
+		if len(hostURLs) == 0 && !containsGoogleHost(urls) {
+			return nil, categorize(CategoryConfig, ErrNoConfiguredHost)
+		}
+	} else if !o.NoDefaultHosts {
+		if !containsRootURL(urls, "googlesource.com") {
+			urls = append(urls, &url.URL{Scheme: "https", Host: "googlesource.com"})
+		}
+		if !containsRootURL(urls, "source.developers.google.com") {
+			urls = append(urls, &url.URL{Scheme: "https", Host: "source.developers.google.com"})
+		}
+	}
+	if len(urls) == 0 {
+		return nil, categorize(CategoryConfig, ErrNoHosts)
+	}
+	if len(o.OnlyHosts) > 0 {
+		if urls = o.filterOnlyHosts(urls); len(urls) == 0 {
+			return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: none of %v is found", o.OnlyHosts))
+		}
+	}
+	return urls, nil
+}
+
+// filterInsecureURLs drops the URLs whose scheme is not https with a warning
+// unless AllowInsecure is set, so that the tokens are not sent in plain text.
+// The cookies for the remaining URLs are all Secure.
+func (o *Options) filterInsecureURLs(urls []*url.URL) []*url.URL {
+	if o.AllowInsecure {
+		return urls
+	}
+	ret := []*url.URL{}
+	for _, u := range urls {
+		if u.Scheme != "https" {
+			cu := *u
+			cu.User = nil
+			o.logf("Warning: skipping %s because the cookie would be sent without https", &cu)
+			continue
+		}
+		ret = append(ret, u)
+	}
+	return ret
+}
+
+// WriteCookies writes the cookies and returns the summary.
+//
+// If tokens cannot be created only for some hosts, the cookies for the other
+// hosts are still written, and the summary is returned with HostErrors. If no
+// token can be created, the output file is left untouched. Use ErrorCategory
+// to tell the kind of the failure.
+func WriteCookies(ctx context.Context, opts *Options) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	hostURLs, err := ParseHosts(opts.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	gitBinary, err := opts.GitBinary()
+	if err != nil {
+		return nil, categorize(CategoryGit, err)
+	}
+	if opts.Verbose {
+		if v, err := gitBinary.Version(ctx); err == nil {
+			opts.verbosef("Using git %v at %s", v, gitBinary.Path)
+		} else {
+			opts.verbosef("Using git at %s (%v)", gitBinary.Path, err)
+		}
+	}
+	// Read git-config at once instead of invoking git for each key.
+	gitConfig, err := gitBinary.ConfigAll(ctx)
+	if err != nil {
+		return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot read git-config: %v", err))
+	}
+	outputFile, err := opts.outputFile(gitConfig)
+	if err != nil {
+		return nil, categorize(CategoryConfig, err)
+	}
+	if opts.MinAge > 0 && !isStreamOutput(outputFile) && !opts.DryRun {
+		if fi, err := os.Stat(outputFile); err == nil {
+			if age := time.Since(fi.ModTime()); age < opts.MinAge {
+				opts.verbosef("Not writing the cookies because %s was written %v ago", outputFile, age)
+				return &Result{Skipped: true}, nil
+			}
+		}
+	}
+	if !opts.Force && !opts.hasSharedCredentials() && opts.URLTokenSource == nil && !credentials.AccountHasCredentials(opts.Account) && !gitConfig.HasCredentials() {
+		return nil, categorize(CategoryConfig, ErrNoCredentials)
+	}
+	urls, err := opts.listURLs(ctx, gitBinary, gitConfig, hostURLs)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.SkipHosts) > 0 {
+		if urls = opts.filterSkipHosts(urls); len(urls) == 0 {
+			return nil, categorize(CategoryToken, xerrors.Errorf("%w: %v", ErrAllHostsSkipped, opts.SkipHosts))
+		}
+	}
+	if opts.Format == FormatHeader {
+		if hosts := urlHosts(urls); len(hosts) > 1 {
+			return nil, categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot write a header for %s: %w", strings.Join(hosts, ", "), ErrMultipleHosts))
+		}
+	}
+
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx = withHTTPClient(ctx, client)
+	ts, err := opts.tokenSource(ctx)
+	if err != nil {
+		return nil, categorize(CategoryToken, credentials.RedactError(err))
+	}
+	tokens, tokenErrs := opts.makeTokens(ctx, gitBinary, ts, urls)
+	now := time.Now()
+	var expiry time.Time
+	var errs HostErrors
+	cookies := []*http.Cookie{}
+	hosts := []string{}
+	hostExpiries := map[string]time.Time{}
+	for i, u := range urls {
+		if tokenErrs[i] != nil {
+			errs = append(errs, &HostError{Host: u.Host, Err: xerrors.Errorf("cookieauth: cannot create a token for %s: %v", u, tokenErrs[i])})
+			continue
+		}
+		token := tokens[i]
+		opts.verbosef("Created a token for %s (expires at %s)", u, token.Expiry.Format(time.RFC3339))
+		if !token.Expiry.IsZero() && !token.Expiry.After(now) {
+			// The token sources refresh the expired tokens, so a new
+			// token that has already expired means the clock is off.
+			opts.logf("Warning: the token for %s expired at %s, before the current time %s. Check if the clock of this machine is correct. Git will reject the cookie", u, token.Expiry.Format(time.RFC3339), now.Format(time.RFC3339))
+		}
+		if !token.Expiry.IsZero() && (expiry.IsZero() || token.Expiry.Before(expiry)) {
+			expiry = token.Expiry
+		}
+		if e, ok := hostExpiries[u.Host]; !token.Expiry.IsZero() && (!ok || token.Expiry.Before(e)) {
+			hostExpiries[u.Host] = token.Expiry
+		}
+		cu := u
+		if opts.WildcardGoogleSource && strings.HasSuffix(u.Hostname(), ".googlesource.com") {
+			// MakeCookies writes the cookies for googlesource.com to
+			// .googlesource.com.
+			cu = &url.URL{Scheme: u.Scheme, Host: "googlesource.com", Path: u.Path}
+		}
+		cs := credentials.MakeNamedCookies(cu, token, opts.cookieName())
+		if opts.SourceDevelopersCookiePath != "" && cu.Hostname() == "source.developers.google.com" && (cu.Path == "" || cu.Path == "/") {
+			for _, c := range cs {
+				c.Path = opts.SourceDevelopersCookiePath
+			}
+		}
+		cookies = append(cookies, cs...)
+		hosts = append(hosts, u.Host)
+	}
+	sort.Strings(hosts)
+	hosts = uniqueStrings(hosts)
+	cookies = dedupeCookies(cookies)
+	// Keep the output stable across runs.
+	sort.SliceStable(cookies, func(i, j int) bool {
+		if cookies[i].Domain != cookies[j].Domain {
+			return cookies[i].Domain < cookies[j].Domain
+		}
+		return cookies[i].Path < cookies[j].Path
+	})
+	if len(cookies) == 0 {
+		return nil, categorize(CategoryToken, xerrors.Errorf("cookieauth: cannot create a token for any host: %w", errs))
+	}
+	res := &Result{Expiry: expiry, Cookies: len(cookies), Hosts: hosts, HostExpiries: hostExpiries}
+
+	outputFiles := append([]string{outputFile}, opts.ExtraOutputPaths...)
+	if opts.DryRun {
+		// Never log the cookie values.
+		for _, f := range outputFiles {
+			opts.logf("Dry run: would write %d cookies to %s", len(cookies), f)
+		}
+		for _, c := range cookies {
+			opts.logf("Dry run: %s%s (expires at %s)", c.Domain, c.Path, c.Expires.Format(time.RFC3339))
+		}
+		return res, errs.errOrNil()
+	}
+	for _, f := range outputFiles {
+		opts.verbosef("Writing %d cookies to %s", len(cookies), f)
+		if err := opts.writeOutputFile(ctx, f, cookies); err != nil {
+			return nil, categorize(CategoryOutput, err)
+		}
+	}
+	return res, errs.errOrNil()
+}
+
+// filterOnlyHosts returns the URLs whose hosts are in OnlyHosts. The hosts in
+// OnlyHosts that are not in urls are logged.
+func (o *Options) filterOnlyHosts(urls []*url.URL) []*url.URL {
+	// OnlyHosts is already validated.
+	onlyURLs, _ := ParseHosts(o.OnlyHosts)
+	found := map[string]bool{}
+	for _, u := range onlyURLs {
+		found[u.Host] = false
+	}
+	filtered := []*url.URL{}
+	for _, u := range urls {
+		if _, ok := found[u.Host]; ok {
+			found[u.Host] = true
+			filtered = append(filtered, u)
+		}
+	}
+	for _, u := range onlyURLs {
+		if !found[u.Host] {
+			o.logf("Ignoring %s because it's not in git-config, the additional hosts, or the default hosts", u.Host)
+		}
+	}
+	return filtered
+}
+
+// filterSkipHosts returns the URLs whose hosts are not in SkipHosts.
+func (o *Options) filterSkipHosts(urls []*url.URL) []*url.URL {
+	skip := map[string]bool{}
+	for _, h := range o.SkipHosts {
+		skip[h] = true
+	}
+	filtered := []*url.URL{}
+	for _, u := range urls {
+		if skip[u.Host] {
+			o.verbosef("Skipping %s", u)
+			continue
+		}
+		filtered = append(filtered, u)
+	}
+	return filtered
+}
+
+// urlHosts returns the distinct hosts of the URLs, sorted.
+func urlHosts(urls []*url.URL) []string {
+	hosts := []string{}
+	for _, u := range urls {
+		hosts = append(hosts, u.Host)
+	}
+	sort.Strings(hosts)
+	return uniqueStrings(hosts)
+}
+
+// uniqueStrings removes the adjacent duplicates in the sorted ss.
+func uniqueStrings(ss []string) []string {
+	unique := ss[:0]
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// rewriteURLs applies url.<base>.insteadOf and url.<base>.pushInsteadOf in
+// git-config so that the cookies are written for the hosts git actually
+// contacts.
+func rewriteURLs(r *credentials.URLRewriter, urls []*url.URL) ([]*url.URL, error) {
+	seen := map[string]bool{}
+	rewritten := []*url.URL{}
+	for _, u := range urls {
+		rus, err := r.Rewrite(u)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot rewrite %s: %v", u, err)
+		}
+		for _, ru := range rus {
+			if !seen[ru.String()] {
+				seen[ru.String()] = true
+				rewritten = append(rewritten, ru)
+			}
+		}
+	}
+	return rewritten, nil
+}
+
+// makeTokens creates tokens for the URLs concurrently. If Verify is set, the
+// tokens are verified as well. The returned slices are in the same order as
+// urls. If ts is non-nil, all tokens are taken from it. Otherwise, they're
+// taken from URLTokenSource if set, or the hosts with the same credentials in
+// git-config share a TokenSource.
+func (o *Options) makeTokens(ctx context.Context, gitBinary credentials.GitBinary, ts oauth2.TokenSource, urls []*url.URL) ([]*oauth2.Token, []error) {
+	tokens := make([]*oauth2.Token, len(urls))
+	errs := make([]error, len(urls))
+	concurrency := o.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	cache := newTokenSourceCache()
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			token, err := retry(ctx, o.MaxRetries, func() (*oauth2.Token, error) {
+				return o.tokenFor(ctx, gitBinary, ts, cache, u)
+			})
+			if err == nil && o.Verify {
+				err = o.verifyToken(ctx, u, token)
+			}
+			// The errors end up in the logs. Make sure that they
+			// have no tokens.
+			tokens[i], errs[i] = token, credentials.RedactError(err)
+		}(i, u)
+	}
+	wg.Wait()
+	return tokens, errs
+}
+
+// dedupeCookies removes the cookies with the same domain, path, and name. The
+// last one wins.
+func dedupeCookies(cookies []*http.Cookie) []*http.Cookie {
+	type key struct{ domain, path, name string }
+	index := map[key]int{}
+	deduped := []*http.Cookie{}
+	for _, c := range cookies {
+		k := key{c.Domain, c.Path, c.Name}
+		if i, ok := index[k]; ok {
+			deduped[i] = c
+			continue
+		}
+		index[k] = len(deduped)
+		deduped = append(deduped, c)
+	}
+	return deduped
+}
+
+// hasSharedCredentials returns true if the tokens for all hosts are created
+// from the same credentials rather than git-config.
+func (o *Options) hasSharedCredentials() bool {
+	return o.TokenSource != nil || o.UseADC || o.KeyFile != "" || o.ImpersonateServiceAccount != ""
+}
+
+// tokenSource returns the TokenSource shared by all hosts. It returns nil if
+// the tokens are created per host based on git-config.
+func (o *Options) tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	return o.tokenSourceWithScopes(ctx, o.Scopes)
+}
+
+// tokenSourceWithScopes is tokenSource with the scopes instead of Scopes.
+func (o *Options) tokenSourceWithScopes(ctx context.Context, scopes []string) (oauth2.TokenSource, error) {
+	if o.TokenSource != nil {
+		return o.TokenSource, nil
+	}
+	if !o.hasSharedCredentials() {
+		return nil, nil
+	}
+	if o.IDTokenAudience != "" {
+		ts, err := credentials.IDTokenSource(ctx, o.IDTokenAudience, o.KeyFile)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot get the ID token source: %w", err)
+		}
+		return oauth2.ReuseTokenSource(nil, ts), nil
+	}
+	var clientOpts []option.ClientOption
+	if o.QuotaProject != "" {
+		ctx = withQuotaProject(ctx, o.QuotaProject)
+		clientOpts = append(clientOpts, option.WithQuotaProject(o.QuotaProject))
+	}
+	// The base credentials need the cloud-platform scope for impersonation.
+	baseScopes := scopes
+	if o.ImpersonateServiceAccount != "" {
+		baseScopes = nil
+	}
+	var ts oauth2.TokenSource
+	var err error
+	if o.KeyFile != "" {
+		ts, err = credentials.KeyFileTokenSourceWithTokenURL(ctx, o.KeyFile, baseScopes, o.TokenURL)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot load the key file: %v", err)
+		}
+	} else {
+		ts, err = credentials.ApplicationDefaultTokenSourceWithTokenURL(ctx, baseScopes, o.TokenURL)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot get the application default credentials: %v", err)
+		}
+	}
+	if o.ImpersonateServiceAccount != "" {
+		ts, err = credentials.ImpersonatedTokenSource(ctx, ts, o.ImpersonateServiceAccount, o.ImpersonateDelegates, scopes, clientOpts...)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot impersonate %s: %v", o.ImpersonateServiceAccount, err)
+		}
+		return ts, nil
+	}
+	return oauth2.ReuseTokenSource(nil, ts), nil
+}
+
+// MakeToken creates a token for the URL in the same way as WriteCookies.
+func (o *Options) MakeToken(ctx context.Context, gitBinary credentials.GitBinary, u *url.URL) (*oauth2.Token, error) {
+	client, err := o.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx = withHTTPClient(ctx, client)
+	ts, err := o.tokenSource(ctx)
+	if err != nil {
+		return nil, credentials.RedactError(err)
+	}
+	token, err := o.tokenFor(ctx, gitBinary, ts, newTokenSourceCache(), u)
+	return token, credentials.RedactError(err)
+}
+
+// tokenFor creates a token for the URL. ts is the one returned by
+// tokenSource, and it's replaced with the one for HostScopes if the host has
+// its own scopes.
+func (o *Options) tokenFor(ctx context.Context, gitBinary credentials.GitBinary, ts oauth2.TokenSource, cache *tokenSourceCache, u *url.URL) (*oauth2.Token, error) {
+	if scopes := o.hostScopes(u); scopes != nil && ts != nil {
+		var err error
+		ts, err = cache.getShared(ctx, o, scopes)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot get a TokenSource: %w", err)
+		}
+	}
+	if ts != nil {
+		return ts.Token()
+	}
+	if o.URLTokenSource != nil {
+		return o.URLTokenSource.TokenForURL(ctx, u)
+	}
+	return o.makeToken(ctx, gitBinary, cache, u)
+}
+
+// hostScopes returns the scopes in HostScopes for the host of the URL, or nil
+// if there's none.
+func (o *Options) hostScopes(u *url.URL) []string {
+	host, err := NormalizeHost(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	return o.HostScopes[host]
+}
+
+// makeToken creates a token for the URL based on git-config and the options.
+// The TokenSource is taken from cache if another host has the same
+// credentials.
+func (o *Options) makeToken(ctx context.Context, gitBinary credentials.GitBinary, cache *tokenSourceCache, u *url.URL) (*oauth2.Token, error) {
+	c, err := gitBinary.CredentialConfigFromGitConfig(ctx, u)
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot get configs: %v", err)
+	}
+	if scopes := o.hostScopes(u); scopes != nil {
+		c.Scopes = scopes
+	} else if len(o.Scopes) > 0 {
+		c.Scopes = o.Scopes
+	}
+	if o.Account != "" {
+		c.Account = o.Account
+	}
+	o.verbosef("Using the account %s for %s", accountName(c.Account), u)
+	ts, err := cache.get(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot get a TokenSource: %w", err)
+	}
+	token, err := ts.Token()
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot get a token: %w", err)
+	}
+	return token, nil
+}
+
+// accountName returns the account for the logs. The empty account is the
+// default account of gcloud.
+func accountName(account string) string {
+	if account == "" {
+		return "gcloud"
+	}
+	return account
+}
+
+// tokenSourceCache keeps the TokenSources created from git-config by their
+// CredentialConfig, so that the hosts with the same credentials share a token
+// within a WriteCookies call.
+type tokenSourceCache struct {
+	mu sync.Mutex
+	m  map[string]oauth2.TokenSource
+}
+
+func newTokenSourceCache() *tokenSourceCache {
+	return &tokenSourceCache{m: map[string]oauth2.TokenSource{}}
+}
+
+// get returns the TokenSource for c, creating it if there's none. The failures
+// are not cached so that a retry creates the TokenSource again.
+func (t *tokenSourceCache) get(ctx context.Context, c *credentials.CredentialConfig) (oauth2.TokenSource, error) {
+	key := strings.Join([]string{
+		c.Account,
+		strings.Join(c.Scopes, ","),
+		strings.Join(c.ServiceAccountDelegateEmails, ","),
+		c.GcloudPath,
+	}, "\n")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ts, ok := t.m[key]; ok {
+		return ts, nil
+	}
+	ts, err := credentials.TokenSourceFromConfig(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	// ReuseTokenSource serializes the Token calls, so the concurrent hosts
+	// wait for the first token instead of creating their own.
+	ts = oauth2.ReuseTokenSource(nil, ts)
+	t.m[key] = ts
+	return ts, nil
+}
+
+// getShared returns the TokenSource of the shared credentials in o with the
+// scopes, creating it if there's none.
+func (t *tokenSourceCache) getShared(ctx context.Context, o *Options, scopes []string) (oauth2.TokenSource, error) {
+	key := "shared\n" + strings.Join(scopes, ",")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ts, ok := t.m[key]; ok {
+		return ts, nil
+	}
+	ts, err := o.tokenSourceWithScopes(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+	t.m[key] = ts
+	return ts, nil
+}
+
+// ParseHosts parses the hosts as https://<host> URLs.
+func ParseHosts(hosts []string) ([]*url.URL, error) {
+	urls := []*url.URL{}
+	for _, h := range hosts {
+		u, err := url.Parse("https://" + h)
+		if err != nil {
+			return nil, xerrors.Errorf("cookieauth: cannot parse the host %s: %v", h, err)
+		}
+		if u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, xerrors.Errorf("cookieauth: %s is not a host name", h)
+		}
+		u.Path = ""
+		if u.Host, err = NormalizeHost(u.Host); err != nil {
+			return nil, xerrors.Errorf("cookieauth: invalid host %s: %v", h, err)
+		}
+		if !containsRootURL(urls, u.Host) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+// NormalizeHost lowercases the host name, removes the trailing dot, and
+// converts an internationalized domain name to punycode. The port is kept
+// as is.
+func NormalizeHost(host string) (string, error) {
+	hostname, port := host, ""
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname, port = host[:i], host[i:]
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	hostname, err := idna.ToASCII(hostname)
+	if err != nil {
+		return "", xerrors.Errorf("cookieauth: cannot convert %s to ASCII: %v", host, err)
+	}
+	return hostname + port, nil
+}
+
+// containsRootURL returns true if urls contain the root URL of the host.
+func containsRootURL(urls []*url.URL, host string) bool {
+	for _, u := range urls {
+		if u.Host == host && (u.Path == "" || u.Path == "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsGoogleHost returns true if urls has googlesource.com,
+// source.developers.google.com, or one of their subdomains.
+func containsGoogleHost(urls []*url.URL) bool {
+	for _, u := range urls {
+		for _, d := range []string{"googlesource.com", "source.developers.google.com"} {
+			if u.Host == d || strings.HasSuffix(u.Host, "."+d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HostErrors is a list of errors that happened for individual hosts. The
+// elements are HostError.
+type HostErrors []error
+
+func (e HostErrors) Error() string {
+	ss := []string{}
+	for _, err := range e {
+		ss = append(ss, err.Error())
+	}
+	return strings.Join(ss, "; ")
+}
+
+func (e HostErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// HostError is an error that happened for a host.
+type HostError struct {
+	Host string
+	Err  error
+}
+
+func (e *HostError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HostError) Unwrap() error {
+	return e.Err
+}
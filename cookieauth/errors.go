@@ -0,0 +1,91 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// Category is the kind of a WriteCookies failure.
+type Category int
+
+const (
+	// CategoryUnknown is for the failures not in the other categories,
+	// such as invalid options.
+	CategoryUnknown Category = iota
+	// CategoryGit is for the failures in finding the git binary.
+	CategoryGit
+	// CategoryConfig is for the failures in reading git-config.
+	CategoryConfig
+	// CategoryToken is for the failures in creating the tokens.
+	CategoryToken
+	// CategoryOutput is for the failures in writing the output file.
+	CategoryOutput
+)
+
+// ErrNoCredentials is returned by WriteCookies if no credentials are
+// available, such as when gcloud is not installed and nothing else is
+// configured. It's CategoryConfig.
+var ErrNoCredentials = xerrors.New("cookieauth: no credentials configured")
+
+// ErrMultipleHosts is returned by WriteCookies if FormatHeader is used with
+// more than one host. It's CategoryConfig.
+var ErrMultipleHosts = xerrors.New("cookieauth: the header format needs a single host")
+
+// ErrNoHosts is returned by WriteCookies if there's no host to write a cookie
+// for, such as when git-config has no URLs and NoDefaultHosts is set. It's
+// CategoryConfig.
+var ErrNoHosts = xerrors.New("cookieauth: no hosts to write cookies for")
+
+// ErrNoConfiguredHost is returned by WriteCookies if RequireConfiguredHost
+// is set and neither git-config nor Hosts has a googlesource.com or
+// source.developers.google.com host. It's CategoryConfig.
+var ErrNoConfiguredHost = xerrors.New("cookieauth: no googlesource.com or source.developers.google.com host is configured")
+
+// ErrAllHostsSkipped is returned by WriteCookies if SkipHosts has all the
+// hosts to write cookies for. The hosts have failed before, and this is not a
+// new failure. It's CategoryToken.
+var ErrAllHostsSkipped = xerrors.New("cookieauth: all hosts are skipped")
+
+// Error is an error with its Category.
+type Error struct {
+	Category Category
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func categorize(c Category, err error) error {
+	return &Error{Category: c, Err: err}
+}
+
+// ErrorCategory returns the Category of an error returned by WriteCookies.
+// HostErrors is CategoryToken.
+func ErrorCategory(err error) Category {
+	if _, ok := err.(HostErrors); ok {
+		return CategoryToken
+	}
+	var e *Error
+	if xerrors.As(err, &e) {
+		return e.Category
+	}
+	return CategoryUnknown
+}
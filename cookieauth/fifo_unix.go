@@ -0,0 +1,56 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cookieauth
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoPollInterval is how often openFIFO retries until a reader opens the
+// pipe.
+const fifoPollInterval = 100 * time.Millisecond
+
+// openFIFO opens the named pipe for writing. It waits until a reader opens
+// the pipe or ctx is done. The returned file is in the blocking mode.
+func openFIFO(ctx context.Context, path string) (*os.File, error) {
+	for {
+		// Opening a pipe without a reader fails with ENXIO in the
+		// non-blocking mode instead of blocking without a way to cancel.
+		fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err == nil {
+			if err := syscall.SetNonblock(fd, false); err != nil {
+				syscall.Close(fd)
+				return nil, err
+			}
+			return os.NewFile(uintptr(fd), path), nil
+		}
+		if err != syscall.ENXIO && err != syscall.EINTR {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		t := time.NewTimer(fifoPollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
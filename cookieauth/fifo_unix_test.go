@@ -0,0 +1,93 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cookieauth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func makeFIFO(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cookies")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestWriteFIFO(t *testing.T) {
+	path, cleanup := makeFIFO(t)
+	defer cleanup()
+
+	type result struct {
+		bs  []byte
+		err error
+	}
+	read := make(chan result)
+	go func() {
+		// Open the reader after the writer starts waiting.
+		time.Sleep(2 * fifoPollInterval)
+		bs, err := ioutil.ReadFile(path)
+		read <- result{bs, err}
+	}()
+	o := &Options{Format: FormatNetscape}
+	if err := o.writeOutputFile(context.Background(), path, testCookies()); err != nil {
+		t.Fatalf("writeOutputFile: %v", err)
+	}
+	r := <-read
+	if r.err != nil {
+		t.Fatalf("cannot read the named pipe: %v", r.err)
+	}
+	if !strings.Contains(string(r.bs), "token1") || !strings.Contains(string(r.bs), "token2") {
+		t.Errorf("the named pipe has %q, want the cookies", r.bs)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("the named pipe is replaced: %v, %v", fi, err)
+	}
+}
+
+func TestWriteFIFONoReader(t *testing.T) {
+	path, cleanup := makeFIFO(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*fifoPollInterval)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- (&Options{}).writeOutputFile(ctx, path, testCookies())
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Errorf("writeOutputFile = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writeOutputFile doesn't return after the context is done")
+	}
+}
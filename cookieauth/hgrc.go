@@ -0,0 +1,99 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// hgrcAuthName returns the name of the [auth] entry for the host. Mercurial
+// splits the keys at the last ".", so the dots in the host are replaced.
+func hgrcAuthName(host string) string {
+	return strings.Replace(host, ".", "_", -1)
+}
+
+// writeHgrc writes an [auth] section with the prefix, username, and password
+// entries for each host. As in .netrc, a leading "." of the domain is dropped
+// because the prefixes have no wildcards.
+func writeHgrc(w io.Writer, cookies []*http.Cookie, username string) error {
+	if _, err := io.WriteString(w, "[auth]\n"); err != nil {
+		return err
+	}
+	machines, passwords := netrcMachines(cookies)
+	for _, m := range machines {
+		name := hgrcAuthName(m)
+		if _, err := fmt.Fprintf(w, "%s.prefix = https://%s\n%s.username = %s\n%s.password = %s\n", name, m, name, username, name, passwords[m]); err != nil {
+			return xerrors.Errorf("cannot write the entry for %s: %w", m, err)
+		}
+	}
+	return nil
+}
+
+// readHgrcFile returns the hgrc file except the [auth] entries for the hosts
+// of the cookies. The other sections and entries are kept as is, including
+// the comments. It returns nil if the file doesn't exist.
+func readHgrcFile(path string, cookies []*http.Cookie) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot read %s: %v", path, err)
+	}
+	machines, _ := netrcMachines(cookies)
+	written := map[string]bool{}
+	for _, m := range machines {
+		written[hgrcAuthName(m)] = true
+	}
+	var buf bytes.Buffer
+	inAuth, dropping := false, false
+	for _, line := range strings.SplitAfter(string(bs), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			inAuth = strings.TrimSpace(trimmed[1:len(trimmed)-1]) == "auth"
+			dropping = false
+		case trimmed != "" && (line[0] == ' ' || line[0] == '\t'):
+			// A continuation line belongs to the previous entry.
+		case inAuth && trimmed != "" && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, ";"):
+			dropping = false
+			if i := strings.IndexByte(trimmed, '='); i >= 0 {
+				key := strings.TrimSpace(trimmed[:i])
+				if j := strings.LastIndexByte(key, '.'); j >= 0 {
+					dropping = written[key[:j]]
+				}
+			}
+		default:
+			dropping = false
+		}
+		if !dropping {
+			buf.WriteString(line)
+		}
+	}
+	kept := terminateLine(buf.Bytes())
+	// Separate the new [auth] section.
+	if len(kept) > 0 && !bytes.HasSuffix(kept, []byte("\n\n")) {
+		kept = append(kept, '\n')
+	}
+	return kept, nil
+}
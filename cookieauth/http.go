@@ -0,0 +1,159 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+)
+
+// defaultHTTPClient is used for the OAuth2 token exchanges and the
+// verification requests unless SOCKS5Proxy is set. It honors ${HTTPS_PROXY},
+// ${HTTP_PROXY}, and ${NO_PROXY} even if http.DefaultTransport is replaced.
+// The GCE metadata server is accessed directly without a proxy by the
+// metadata client.
+var defaultHTTPClient = &http.Client{
+	Transport: newTransport(http.ProxyFromEnvironment, newDialer().DialContext),
+}
+
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+func newTransport(proxyFunc func(*http.Request) (*url.URL, error), dial func(ctx context.Context, network, addr string) (net.Conn, error)) *http.Transport {
+	return &http.Transport{
+		Proxy:                 proxyFunc,
+		DialContext:           dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// parseSOCKS5Proxy parses "host:port" or "socks5://[user:password@]host:port".
+func parseSOCKS5Proxy(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "socks5://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: invalid SOCKS5 proxy: %v", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, xerrors.Errorf("cookieauth: invalid SOCKS5 proxy %s: the scheme must be socks5", u.Host)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, xerrors.Errorf("cookieauth: invalid SOCKS5 proxy %s: needs a host and a port", u.Host)
+	}
+	return u, nil
+}
+
+// newSOCKS5Client returns an HTTP client that connects through the SOCKS5
+// proxy. The proxy environment variables are ignored. The host names are
+// resolved by the proxy.
+func newSOCKS5Client(proxyURL *url.URL) (*http.Client, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, newDialer())
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot use the SOCKS5 proxy %s: %v", proxyURL.Host, err)
+	}
+	// The SOCKS5 dialer of x/net/proxy supports a context.
+	cd := d.(proxy.ContextDialer)
+	return &http.Client{Transport: newTransport(nil, cd.DialContext)}, nil
+}
+
+// socks5Clients caches the clients by the SOCKS5 proxy URLs, so that the
+// refreshes in daemon mode reuse the connections to the proxy instead of
+// leaving the idle ones of a new transport each time.
+var socks5Clients sync.Map
+
+// httpClient returns the HTTP client for the options.
+func (o *Options) httpClient() (*http.Client, error) {
+	if o.SOCKS5Proxy == "" {
+		return defaultHTTPClient, nil
+	}
+	u, err := parseSOCKS5Proxy(o.SOCKS5Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := socks5Clients.Load(u.String()); ok {
+		return c.(*http.Client), nil
+	}
+	c, err := newSOCKS5Client(u)
+	if err != nil {
+		return nil, err
+	}
+	// Another goroutine may have stored one in the meantime.
+	actual, _ := socks5Clients.LoadOrStore(u.String(), c)
+	return actual.(*http.Client), nil
+}
+
+// httpClientFromContext returns the HTTP client set by withHTTPClient.
+func httpClientFromContext(ctx context.Context) *http.Client {
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		return c
+	}
+	return defaultHTTPClient
+}
+
+// quotaProjectTransport sets X-Goog-User-Project to bill the requests to the
+// project.
+type quotaProjectTransport struct {
+	base    http.RoundTripper
+	project string
+}
+
+func (t *quotaProjectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request.
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		req2.Header[k] = v
+	}
+	req2.Header.Set("X-Goog-User-Project", t.project)
+	return t.base.RoundTrip(req2)
+}
+
+// withQuotaProject returns a context that makes the oauth2 package bill the
+// token exchanges to the project.
+func withQuotaProject(ctx context.Context, project string) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+		Transport: &quotaProjectTransport{base: httpClientFromContext(ctx).Transport, project: project},
+	})
+}
+
+// withHTTPClient returns a context that makes the oauth2 package and the
+// verification requests use client.
+func withHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aki237/nscjar"
+	"golang.org/x/xerrors"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// readCookieFile reads the cookies in the Netscape cookie file. It returns
+// nil if the file doesn't exist.
+func readCookieFile(path string) ([]*http.Cookie, error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot read %s: %v", path, err)
+	}
+	// nscjar treats only "# " as a comment. Drop the other comments, but
+	// keep the HttpOnly cookies.
+	var buf bytes.Buffer
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.HasPrefix(line, "#") && !strings.HasPrefix(line, httpOnlyPrefix) {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	cookies, err := nscjar.Parser{}.Unmarshal(&buf)
+	if err != nil {
+		return nil, xerrors.Errorf("cookieauth: cannot parse %s: %v", path, err)
+	}
+	for _, c := range cookies {
+		if strings.HasPrefix(c.Domain, httpOnlyPrefix) {
+			c.Domain = strings.TrimPrefix(c.Domain, httpOnlyPrefix)
+			c.HttpOnly = true
+		}
+	}
+	return cookies, nil
+}
+
+// mergeCookies returns the existing cookies except the ones for the domains in
+// cookies, followed by cookies.
+func mergeCookies(existing, cookies []*http.Cookie) []*http.Cookie {
+	domains := map[string]bool{}
+	for _, c := range cookies {
+		domains[c.Domain] = true
+	}
+	merged := []*http.Cookie{}
+	for _, c := range existing {
+		if !domains[c.Domain] {
+			merged = append(merged, c)
+		}
+	}
+	return append(merged, cookies...)
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// netrcMachines returns the machine names for the cookies in the order. A
+// leading "." of the domain is dropped because .netrc has no wildcards.
+func netrcMachines(cookies []*http.Cookie) ([]string, map[string]string) {
+	machines := []string{}
+	passwords := map[string]string{}
+	for _, c := range cookies {
+		m := strings.TrimPrefix(c.Domain, ".")
+		if _, ok := passwords[m]; ok {
+			continue
+		}
+		machines = append(machines, m)
+		passwords[m] = c.Value
+	}
+	return machines, passwords
+}
+
+// writeNetrc writes a "machine <host> login <user> password <token>" line for
+// each host.
+func writeNetrc(w io.Writer, cookies []*http.Cookie, username string) error {
+	machines, passwords := netrcMachines(cookies)
+	for _, m := range machines {
+		if _, err := fmt.Fprintf(w, "machine %s login %s password %s\n", m, username, passwords[m]); err != nil {
+			return xerrors.Errorf("cannot write the entry for %s: %w", m, err)
+		}
+	}
+	return nil
+}
+
+// readNetrcFile returns the entries in the .netrc file except the ones for
+// the machines of the cookies. An entry starts at a line whose first word is
+// "machine" or "default" and continues until the next entry. The "default"
+// entry is returned separately because it must come last. It returns nil if
+// the file doesn't exist.
+func readNetrcFile(path string, cookies []*http.Cookie) (machines, def []byte, err error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, xerrors.Errorf("cookieauth: cannot read %s: %v", path, err)
+	}
+	_, written := netrcMachines(cookies)
+	var machineBuf, defBuf bytes.Buffer
+	buf := &machineBuf
+	for _, line := range strings.SplitAfter(string(bs), "\n") {
+		if fs := strings.Fields(line); len(fs) > 0 {
+			switch fs[0] {
+			case "machine":
+				buf = &machineBuf
+				if _, ok := written[fieldAfter(fs, "machine")]; ok {
+					buf = nil
+				}
+			case "default":
+				buf = &defBuf
+			}
+		}
+		if buf != nil {
+			buf.WriteString(line)
+		}
+	}
+	return terminateLine(machineBuf.Bytes()), terminateLine(defBuf.Bytes()), nil
+}
+
+func terminateLine(bs []byte) []byte {
+	if len(bs) > 0 && !bytes.HasSuffix(bs, []byte("\n")) {
+		bs = append(bs, '\n')
+	}
+	return bs
+}
+
+func fieldAfter(fs []string, name string) string {
+	for i := 0; i+1 < len(fs); i++ {
+		if fs[i] == name {
+			return fs[i+1]
+		}
+	}
+	return ""
+}
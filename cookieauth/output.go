@@ -0,0 +1,569 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aki237/nscjar"
+	"github.com/google/googlesource-auth-tools/credentials"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// CookieFileEnv is the environment variable for the output file path.
+	CookieFileEnv = "GOOGLESOURCE_COOKIE_FILE"
+
+	cookieFileName = "googlesource-cookieauth-cookie"
+
+	defaultFileMode os.FileMode = 0600
+	defaultDirMode  os.FileMode = 0700
+)
+
+// outputFile returns the path to the output file. It's taken from the first
+// non-empty value of OutputPath, ${GOOGLESOURCE_COOKIE_FILE}, and
+// google.cookieFile in git-config. If none of them is set, it defaults to
+// defaultOutputFile. FormatHeader writes to OutputPath or stdout.
+func (o *Options) outputFile(gitConfig *credentials.GitConfig) (string, error) {
+	if o.OutputPath != "" {
+		return o.OutputPath, nil
+	}
+	if o.Format == FormatHeader {
+		// Never overwrite the cookie file with a header.
+		return "-", nil
+	}
+	if p := os.Getenv(CookieFileEnv); p != "" {
+		return p, nil
+	}
+	p, err := gitConfig.PathConfig("google.cookieFile")
+	if err != nil {
+		return "", xerrors.Errorf("cookieauth: cannot read google.cookieFile in git-config: %v", err)
+	}
+	if p != "" {
+		return p, nil
+	}
+	return defaultOutputFile()
+}
+
+// OutputPath returns the absolute path to the output file that WriteCookies
+// writes to. See OutputPath in Options for how it's resolved. It's an error
+// if the output is stdout or a file descriptor.
+func OutputPath(ctx context.Context, opts *Options) (string, error) {
+	gitBinary, err := opts.GitBinary()
+	if err != nil {
+		return "", categorize(CategoryGit, err)
+	}
+	gitConfig, err := gitBinary.ConfigAll(ctx)
+	if err != nil {
+		return "", categorize(CategoryConfig, xerrors.Errorf("cookieauth: cannot read git-config: %v", err))
+	}
+	p, err := opts.outputFile(gitConfig)
+	if err != nil {
+		return "", categorize(CategoryConfig, err)
+	}
+	if isStreamOutput(p) {
+		return "", xerrors.Errorf("cookieauth: the output is not a file: %s", p)
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", xerrors.Errorf("cookieauth: cannot get the absolute path of %s: %v", p, err)
+	}
+	return abs, nil
+}
+
+// defaultOutputFile returns the default path to the output file.
+//
+// On Windows, it's %LOCALAPPDATA%\git-credential-cache\. Otherwise, if
+// ${XDG_CACHE_HOME} is set, it's $XDG_CACHE_HOME/git-credential-cache/. If
+// not, it's $HOME/.cache/git-credential-cache/ if $HOME/.cache exists. The
+// legacy location $HOME/.git-credential-cache/ is used if the cookie file
+// already exists there or if none of the above is available.
+func defaultOutputFile() (string, error) {
+	if runtime.GOOS == "windows" {
+		if d := os.Getenv("LOCALAPPDATA"); d != "" {
+			return filepath.Join(d, "git-credential-cache", cookieFileName), nil
+		}
+	} else if d := os.Getenv("XDG_CACHE_HOME"); d != "" {
+		return filepath.Join(d, "git-credential-cache", cookieFileName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		u, err := user.Current()
+		if err != nil {
+			return "", xerrors.Errorf("cookieauth: cannot get the home directory: %v", err)
+		}
+		home = u.HomeDir
+	}
+	legacy := filepath.Join(home, ".git-credential-cache", cookieFileName)
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(filepath.Join(home, ".cache")); err == nil && fi.IsDir() {
+			return filepath.Join(home, ".cache", "git-credential-cache", cookieFileName), nil
+		}
+	}
+	return legacy, nil
+}
+
+var (
+	fdFilesMu sync.Mutex
+	// fdFiles keeps the files for the inherited file descriptors so that
+	// the finalizer of os.File never closes them.
+	fdFiles = map[uintptr]*os.File{}
+)
+
+// isStreamOutput returns true if the output is stdout ("-") or an inherited
+// file descriptor ("&N").
+func isStreamOutput(outputFile string) bool {
+	return outputFile == "-" || strings.HasPrefix(outputFile, "&")
+}
+
+// streamOutput returns the writer for "-" or "&N".
+func streamOutput(outputFile string) (io.Writer, string, error) {
+	if outputFile == "-" {
+		return os.Stdout, "stdout", nil
+	}
+	fd, err := strconv.ParseUint(strings.TrimPrefix(outputFile, "&"), 10, 32)
+	if err != nil {
+		return nil, "", xerrors.Errorf("cookieauth: invalid file descriptor: %s", outputFile)
+	}
+	name := fmt.Sprintf("file descriptor %d", fd)
+	fdFilesMu.Lock()
+	defer fdFilesMu.Unlock()
+	f, ok := fdFiles[uintptr(fd)]
+	if !ok {
+		f = os.NewFile(uintptr(fd), name)
+		if f == nil {
+			return nil, "", xerrors.Errorf("cookieauth: invalid file descriptor: %s", outputFile)
+		}
+		fdFiles[uintptr(fd)] = f
+	}
+	return f, name, nil
+}
+
+// writeOutputFile writes the cookies to the output file. "-" writes to
+// stdout, and "&N" writes to the inherited file descriptor N. The file
+// descriptor is not closed. If the output file is a named pipe, it's written
+// in place instead of being replaced.
+func (o *Options) writeOutputFile(ctx context.Context, outputFile string, cookies []*http.Cookie) error {
+	if isStreamOutput(outputFile) {
+		w, name, err := streamOutput(outputFile)
+		if err != nil {
+			return err
+		}
+		cw := &countingWriter{w: w}
+		if err := o.writeOutput(cw, cookies); err != nil {
+			return xerrors.Errorf("cookieauth: cannot write the cookies: %v", err)
+		}
+		o.verbosef("Wrote %d bytes to %s", cw.n, name)
+		return nil
+	}
+	// Catch the common mistakes before the syscall errors hide them.
+	if fi, err := os.Stat(outputFile); err == nil && fi.IsDir() {
+		return xerrors.Errorf("cookieauth: %s is a directory. The output path, such as google.cookieFile, must be a file path", outputFile)
+	} else if err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		return o.writeFIFO(ctx, outputFile, cookies)
+	}
+	if f := nonDirectoryAncestor(filepath.Dir(outputFile)); f != "" {
+		return xerrors.Errorf("cookieauth: cannot create the output directory for %s: %s is not a directory", outputFile, f)
+	}
+	if err := os.MkdirAll(filepath.Dir(outputFile), o.dirMode()); err != nil {
+		return xerrors.Errorf("cookieauth: cannot create the output directory: %v", err)
+	}
+	if o.Append {
+		return o.appendOutputFile(outputFile, cookies)
+	}
+	// The existing .netrc or hgrc entries to keep, before and after the new
+	// ones.
+	var netrc, netrcDefault []byte
+	if o.Merge && o.Format == FormatNetrc {
+		var err error
+		netrc, netrcDefault, err = readNetrcFile(outputFile, cookies)
+		if err != nil {
+			o.logf("Cannot merge with the existing entries. Overwriting %s: %v", outputFile, err)
+		}
+	} else if o.Merge && o.Format == FormatHgrc {
+		var err error
+		netrc, err = readHgrcFile(outputFile, cookies)
+		if err != nil {
+			o.logf("Cannot merge with the existing entries. Overwriting %s: %v", outputFile, err)
+		}
+	} else if o.Merge {
+		existing, err := readCookieFile(outputFile)
+		if err != nil {
+			o.logf("Cannot merge with the existing cookies. Overwriting %s: %v", outputFile, err)
+		} else {
+			cookies = mergeCookies(existing, cookies)
+		}
+	}
+	var check func(string) error
+	if o.VerifyOutput && (o.Format == "" || o.Format == FormatNetscape || o.Format == FormatCurl) {
+		check = func(path string) error { return verifyCookieFile(path, cookies) }
+	}
+	cw := &countingWriter{}
+	if err := writeFileAtomically(outputFile, o.fileMode(), func(w io.Writer) error {
+		cw.w = w
+		if _, err := cw.Write(netrc); err != nil {
+			return err
+		}
+		if err := o.writeOutput(cw, cookies); err != nil {
+			return err
+		}
+		_, err := cw.Write(netrcDefault)
+		return err
+	}, check); err != nil {
+		return err
+	}
+	o.verbosef("Wrote %d bytes to %s", cw.n, outputFile)
+	return nil
+}
+
+// writeFIFO writes the cookies to the named pipe and closes it so that the
+// reader gets EOF. It waits until a reader opens the pipe or ctx is done.
+// Merge, Append, and VerifyOutput are ignored because the pipe cannot be read
+// back.
+func (o *Options) writeFIFO(ctx context.Context, outputFile string, cookies []*http.Cookie) error {
+	f, err := openFIFO(ctx, outputFile)
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot open the named pipe: %v", err)
+	}
+	cw := &countingWriter{w: f}
+	err = o.writeOutput(cw, cookies)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot write to the named pipe: %v", err)
+	}
+	o.verbosef("Wrote %d bytes to the named pipe %s", cw.n, outputFile)
+	return nil
+}
+
+// appendOutputFile appends the cookies to the output file after a comment
+// with the current time.
+func (o *Options) appendOutputFile(outputFile string, cookies []*http.Cookie) error {
+	f, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, o.fileMode())
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot open the output file: %v", err)
+	}
+	cw := &countingWriter{w: f}
+	_, err = fmt.Fprintf(cw, "\n# Refreshed at %s\n", time.Now().Format(time.RFC3339))
+	if err == nil {
+		err = o.writeOutput(cw, cookies)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot append to the output file: %v", err)
+	}
+	o.verbosef("Appended %d bytes to %s", cw.n, outputFile)
+	return nil
+}
+
+// nonDirectoryAncestor returns the closest existing path among dir and its
+// ancestors if it's not a directory. It returns an empty string otherwise.
+func nonDirectoryAncestor(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if fi, err := os.Stat(d); err == nil {
+			if fi.IsDir() {
+				return ""
+			}
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// writeOutput writes the cookies in the Format.
+func (o *Options) writeOutput(w io.Writer, cookies []*http.Cookie) error {
+	switch o.Format {
+	case FormatJSON:
+		return writeJSON(w, cookies)
+	case FormatCookieJar:
+		return writeCookieJarJSON(w, cookies)
+	case FormatCurl:
+		if o.NoHeader {
+			return writeCurlCookies(w, cookies)
+		}
+		return writeCurlCookieJar(w, cookies)
+	case FormatNetrc:
+		return writeNetrc(w, cookies, o.username())
+	case FormatHgrc:
+		return writeHgrc(w, cookies, o.username())
+	case FormatHeader:
+		return writeHeader(w, cookies)
+	default:
+		if o.NoHeader {
+			return writeCookies(w, cookies)
+		}
+		return writeCookieJar(w, cookies)
+	}
+}
+
+func writeCookieJar(w io.Writer, cookies []*http.Cookie) error {
+	if _, err := fmt.Fprintf(w, "# Created by %s at %s\n", os.Args[0], time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return writeCookies(w, cookies)
+}
+
+// writeCookies writes the cookie lines of writeCookieJar without the header.
+// The session cookies, such as the ones for the tokens without expiry, expire
+// at 0 as in curl.
+func writeCookies(w io.Writer, cookies []*http.Cookie) error {
+	p := nscjar.Parser{}
+	for _, c := range cookies {
+		if c.Expires.IsZero() {
+			cc := *c
+			cc.Expires = time.Unix(0, 0)
+			c = &cc
+		}
+		if err := p.Marshal(w, c); err != nil {
+			return xerrors.Errorf("cannot write the cookie for %s%s: %w", c.Domain, c.Path, err)
+		}
+	}
+	return nil
+}
+
+// curlHeader is the header curl writes, except the last line.
+const curlHeader = `# Netscape HTTP Cookie File
+# https://curl.se/docs/http-cookies.html
+# This file was generated by googlesource-cookieauth! Edit at your own risk.
+
+`
+
+// writeCurlCookieJar writes the cookies in the format curl writes with
+// --cookie-jar. Unlike nscjar, the include-subdomains flag is TRUE only for
+// the domains starting with ".", and the session cookies expire at 0.
+func writeCurlCookieJar(w io.Writer, cookies []*http.Cookie) error {
+	if _, err := io.WriteString(w, curlHeader); err != nil {
+		return err
+	}
+	return writeCurlCookies(w, cookies)
+}
+
+// writeCurlCookies writes the cookie lines of writeCurlCookieJar without the
+// header.
+func writeCurlCookies(w io.Writer, cookies []*http.Cookie) error {
+	for _, c := range cookies {
+		if c.Name == "" || c.Value == "" {
+			return xerrors.Errorf("cannot write the cookie for %s%s: not a valid cookie", c.Domain, c.Path)
+		}
+		prefix := ""
+		if c.HttpOnly {
+			prefix = "#HttpOnly_"
+		}
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		if _, err := fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%d\t%s\t%s\n", prefix, c.Domain, curlBool(strings.HasPrefix(c.Domain, ".")), path, curlBool(c.Secure), expires, c.Name, c.Value); err != nil {
+			return xerrors.Errorf("cannot write the cookie for %s%s: %w", c.Domain, c.Path, err)
+		}
+	}
+	return nil
+}
+
+func curlBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// writeHeader writes the cookies as a "Cookie:" request header line. The
+// cookies for the -review host have the same name and value, so they are
+// written once.
+func writeHeader(w io.Writer, cookies []*http.Cookie) error {
+	seen := map[string]bool{}
+	pairs := []string{}
+	for _, c := range cookies {
+		p := c.Name + "=" + c.Value
+		if !seen[p] {
+			seen[p] = true
+			pairs = append(pairs, p)
+		}
+	}
+	_, err := fmt.Fprintf(w, "Cookie: %s\n", strings.Join(pairs, "; "))
+	return err
+}
+
+type jsonCookie struct {
+	Host    string    `json:"host"`
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+	Path    string    `json:"path"`
+	Secure  bool      `json:"secure"`
+	Expires time.Time `json:"expires"`
+}
+
+func writeJSON(w io.Writer, cookies []*http.Cookie) error {
+	jcs := []jsonCookie{}
+	for _, c := range cookies {
+		jcs = append(jcs, jsonCookie{
+			Host:    c.Domain,
+			Name:    c.Name,
+			Value:   c.Value,
+			Path:    c.Path,
+			Secure:  c.Secure,
+			Expires: c.Expires,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jcs)
+}
+
+// cookieJarCookie has the fields of http.Cookie that a cookiejar.Jar uses,
+// with the same names, so that it can be unmarshaled to http.Cookie.
+type cookieJarCookie struct {
+	Name     string
+	Value    string
+	Path     string
+	Domain   string
+	Expires  time.Time
+	Secure   bool
+	HttpOnly bool
+}
+
+// writeCookieJarJSON writes the cookies as a JSON array of http.Cookie.
+func writeCookieJarJSON(w io.Writer, cookies []*http.Cookie) error {
+	ccs := []cookieJarCookie{}
+	for _, c := range cookies {
+		ccs = append(ccs, cookieJarCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ccs)
+}
+
+func (o *Options) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return defaultFileMode
+	}
+	return o.FileMode
+}
+
+func (o *Options) dirMode() os.FileMode {
+	if o.DirMode == 0 {
+		return defaultDirMode
+	}
+	return o.DirMode
+}
+
+// writeFileAtomically writes to a temporary file in the same directory and
+// renames it to path. If check is non-nil, it's called with the path to the
+// temporary file before the rename. The existing file is left untouched if
+// write or check fails.
+func writeFileAtomically(path string, mode os.FileMode, write func(io.Writer) error, check func(string) error) (err error) {
+	// ioutil.TempFile creates the file with 0600. Change the mode before
+	// writing anything so that the file is never more permissive than
+	// requested.
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot create a temporary output file: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := f.Chmod(mode); err != nil {
+		return xerrors.Errorf("cookieauth: cannot change the mode of the output file: %v", err)
+	}
+	if err := write(f); err != nil {
+		return xerrors.Errorf("cookieauth: cannot write the output file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("cookieauth: cannot close the output file: %v", err)
+	}
+	if check != nil {
+		if err := check(f.Name()); err != nil {
+			return xerrors.Errorf("cookieauth: the written file is broken. Keeping %s: %v", path, err)
+		}
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return xerrors.Errorf("cookieauth: cannot rename the output file: %v", err)
+	}
+	return nil
+}
+
+// verifyCookieFile parses the cookie file at path and checks that it has the
+// same number of cookies for the same domains as cookies.
+func verifyCookieFile(path string, cookies []*http.Cookie) error {
+	parsed, err := readCookieFile(path)
+	if err != nil {
+		return err
+	}
+	got, want := cookieDomains(parsed), cookieDomains(cookies)
+	if !reflect.DeepEqual(got, want) {
+		return xerrors.Errorf("cookieauth: got %d cookies for %v, want %d cookies for %v", len(got), got, len(want), want)
+	}
+	return nil
+}
+
+// cookieDomains returns the domains of the cookies, sorted.
+func cookieDomains(cookies []*http.Cookie) []string {
+	domains := []string{}
+	for _, c := range cookies {
+		domains = append(domains, c.Domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
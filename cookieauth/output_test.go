@@ -0,0 +1,255 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+var errWrite = errors.New("disk full")
+
+// failingWriter fails after n successful writes.
+type failingWriter struct {
+	n int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errWrite
+	}
+	f.n--
+	return len(p), nil
+}
+
+func testCookies() []*http.Cookie {
+	expires := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	return []*http.Cookie{
+		{Name: "o", Value: "token1", Domain: ".googlesource.com", Path: "/", Expires: expires, Secure: true},
+		{Name: "o", Value: "token2", Domain: "source.developers.google.com", Path: "/", Expires: expires, Secure: true},
+	}
+}
+
+func TestWriteCookieJarError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		n    int
+	}{
+		{
+			name: "header",
+			n:    0,
+		},
+		{
+			name: "first cookie",
+			n:    1,
+		},
+		{
+			name: "second cookie",
+			n:    2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := writeCookieJar(&failingWriter{n: tc.n}, testCookies())
+			if err == nil {
+				t.Fatal("writeCookieJar succeeded with a failing writer")
+			}
+			if !xerrors.Is(err, errWrite) {
+				t.Errorf("writeCookieJar returned %v, want %v", err, errWrite)
+			}
+		})
+	}
+}
+
+func TestWriteCookieJarInvalidCookie(t *testing.T) {
+	cookies := append(testCookies(), &http.Cookie{Domain: "example.com"})
+	if err := writeCookieJar(ioutil.Discard, cookies); err == nil {
+		t.Fatal("writeCookieJar succeeded with an invalid cookie")
+	}
+}
+
+func TestWriteFileAtomicallyError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookieauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookies")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFileAtomically(path, 0600, func(w io.Writer) error {
+		return writeCookieJar(&failingWriter{n: 1}, testCookies())
+	}, nil)
+	if err == nil {
+		t.Fatal("writeFileAtomically succeeded with a failing write")
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "old" {
+		t.Errorf("the existing file is overwritten: %q", bs)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("the temporary file is left: %d files in the directory", len(fis))
+	}
+}
+
+func TestVerifyCookieFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "cookieauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := writeCookieJar(f, testCookies()); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyCookieFile(f.Name(), testCookies()); err != nil {
+		t.Errorf("verifyCookieFile returned %v for the written cookies", err)
+	}
+	if err := verifyCookieFile(f.Name(), testCookies()[:1]); err == nil {
+		t.Error("verifyCookieFile succeeded with a different number of cookies")
+	}
+	cookies := testCookies()
+	cookies[1].Domain = "example.com"
+	if err := verifyCookieFile(f.Name(), cookies); err == nil {
+		t.Error("verifyCookieFile succeeded with different domains")
+	}
+}
+
+func TestWriteCurlCookieJar(t *testing.T) {
+	expires := time.Date(2037, 7, 1, 12, 0, 0, 0, time.UTC)
+	cookies := []*http.Cookie{
+		{Name: "o", Value: "token2", Domain: "gerrit.googlesource.test", Path: "/p", Expires: expires, HttpOnly: true},
+		{Name: "o", Value: "token1", Domain: ".googlesource.test", Path: "/", Expires: expires},
+		{Name: "o", Value: "token3", Domain: "source.developers.google.com", Secure: true},
+	}
+	// Written by curl 7.88.1 with --cookie-jar for the first two cookies.
+	want := `# Netscape HTTP Cookie File
+# https://curl.se/docs/http-cookies.html
+# This file was generated by libcurl! Edit at your own risk.
+
+#HttpOnly_gerrit.googlesource.test	FALSE	/p	FALSE	2130062400	o	token2
+.googlesource.test	TRUE	/	FALSE	2130062400	o	token1
+source.developers.google.com	FALSE	/	TRUE	0	o	token3
+`
+	var buf bytes.Buffer
+	if err := writeCurlCookieJar(&buf, cookies); err != nil {
+		t.Fatal(err)
+	}
+	// Only the header line about the generator differs.
+	got := strings.Replace(buf.String(), "googlesource-cookieauth!", "libcurl!", 1)
+	if got != want {
+		t.Errorf("writeCurlCookieJar() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteCookieJarJSONRoundTrip(t *testing.T) {
+	want := testCookies()
+	want[1].HttpOnly = true
+	var buf bytes.Buffer
+	if err := writeCookieJarJSON(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got []*http.Cookie
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("cannot unmarshal the output to []*http.Cookie: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writeCookieJarJSON() round-trips to %+v, want %+v", got, want)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &url.URL{Scheme: "https", Host: "gerrit.googlesource.com", Path: "/"}
+	// The jar drops the expired test cookies.
+	for _, c := range got {
+		c.Expires = time.Now().Add(time.Hour)
+	}
+	jar.SetCookies(u, got)
+	if cs := jar.Cookies(u); len(cs) != 1 || cs[0].Value != "token1" {
+		t.Errorf("the jar returned %v for %s, want the cookie for .googlesource.com", cs, u)
+	}
+}
+
+func TestReadHgrcFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "cookieauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`[ui]
+username = me
+
+[auth]
+other.prefix = https://example.com
+other.password = secret
+googlesource_com.prefix = https://googlesource.com
+googlesource_com.password = old
+  continued
+# comment
+[extensions]
+rebase =
+`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[ui]
+username = me
+
+[auth]
+other.prefix = https://example.com
+other.password = secret
+# comment
+[extensions]
+rebase =
+
+`
+	got, err := readHgrcFile(f.Name(), testCookies())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("readHgrcFile() = %q, want %q", got, want)
+	}
+}
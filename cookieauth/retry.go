@@ -0,0 +1,85 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	initialRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// retry calls f until it succeeds, up to maxRetries times after the first
+// attempt. It waits with an exponential backoff with jitter between the
+// attempts. Non-retryable errors are returned immediately.
+func retry(ctx context.Context, maxRetries int, f func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	backoff := initialRetryBackoff
+	for i := 0; ; i++ {
+		token, err := f()
+		if err == nil || i >= maxRetries || !isRetryable(err) {
+			return token, err
+		}
+		// Wait between backoff/2 and backoff.
+		d := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// isRetryable returns true only if err is known to be transient: a 429 or 5xx
+// response of the token endpoint or a Google API, or a network timeout or a
+// temporary network error. The others, such as a missing gcloud or an
+// invalid key file, are permanent and fail fast.
+func isRetryable(err error) bool {
+	if xerrors.Is(err, context.Canceled) || xerrors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if xerrors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.Code)
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if xerrors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && isRetryableStatus(retrieveErr.Response.StatusCode)
+	}
+	var netErr net.Error
+	if xerrors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+	"google.golang.org/api/googleapi"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "token endpoint 503",
+			err:  xerrors.Errorf("cookieauth: cannot get a token: %w", &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}),
+			want: true,
+		},
+		{
+			name: "token endpoint 429",
+			err:  &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusTooManyRequests}},
+			want: true,
+		},
+		{
+			name: "token endpoint 400",
+			err:  &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}},
+			want: false,
+		},
+		{
+			name: "Google API 500",
+			err:  xerrors.Errorf("credentials: cannot obtain a credential: %w", &googleapi.Error{Code: http.StatusInternalServerError}),
+			want: true,
+		},
+		{
+			name: "Google API 403",
+			err:  &googleapi.Error{Code: http.StatusForbidden},
+			want: false,
+		},
+		{
+			name: "network timeout",
+			err:  xerrors.Errorf("cookieauth: cannot get a token: %w", &url.Error{Op: "Post", URL: "https://oauth2.googleapis.com/token", Err: timeoutError{}}),
+			want: true,
+		},
+		{
+			name: "canceled",
+			err:  xerrors.Errorf("credentials: failed to run gcloud: %w", context.Canceled),
+			want: false,
+		},
+		{
+			name: "missing gcloud",
+			err:  xerrors.Errorf("credentials: cannot find the gcloud binary: %v", xerrors.New(`exec: "gcloud": executable file not found in $PATH`)),
+			want: false,
+		},
+		{
+			name: "key file accessible by others",
+			err:  xerrors.New("credentials: the key file key.json is accessible by others (-rw-r--r--). Run chmod 600 on it"),
+			want: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetryable(tc.err); got != tc.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryFailsFast(t *testing.T) {
+	calls := 0
+	_, err := retry(context.Background(), 3, func() (*oauth2.Token, error) {
+		calls++
+		return nil, xerrors.New("credentials: cannot parse the key file key.json as a service account key")
+	})
+	if err == nil {
+		t.Fatal("retry succeeded, want an error")
+	}
+	if calls != 1 {
+		t.Errorf("called %d times, want 1", calls)
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	calls := 0
+	token, err := retry(context.Background(), 3, func() (*oauth2.Token, error) {
+		calls++
+		if calls < 2 {
+			return nil, &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}
+		}
+		return &oauth2.Token{AccessToken: "token"}, nil
+	})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if token.AccessToken != "token" || calls != 2 {
+		t.Errorf("retry = %v after %d calls, want the token after 2", token, calls)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cookieauth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/xerrors"
+)
+
+const defaultVerifyTimeout = 10 * time.Second
+
+// verifyToken makes an authenticated HEAD request to https://<host>/a/ and
+// returns an error if the server rejects the token.
+func (o *Options) verifyToken(ctx context.Context, u *url.URL, token *oauth2.Token) error {
+	timeout := o.VerifyTimeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	verifyURL := &url.URL{Scheme: "https", Host: u.Host, Path: "/a/"}
+	req, err := http.NewRequest(http.MethodHead, verifyURL.String(), nil)
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot create a request to %s: %v", verifyURL, err)
+	}
+	token.SetAuthHeader(req)
+	resp, err := httpClientFromContext(ctx).Do(req.WithContext(ctx))
+	if err != nil {
+		return xerrors.Errorf("cookieauth: cannot verify the token against %s: %v", verifyURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return xerrors.Errorf("cookieauth: %s rejected the token: %s", verifyURL, resp.Status)
+	}
+	return nil
+}
@@ -15,11 +15,13 @@
 package credentials
 
 import (
+	"bytes"
 	"context"
 	"net/url"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"golang.org/x/xerrors"
 )
@@ -91,6 +93,14 @@ type GitBinary struct {
 	Path string
 	// Configs are the additional Git configs specified via "-c".
 	Configs []string
+	// ConfigFile is an absolute path to a git-config file read instead of
+	// the global one (~/.gitconfig) through ${GIT_CONFIG_GLOBAL}. Git older
+	// than 2.32 doesn't support it, and the file is included after the
+	// global one instead. Configs take precedence over it in either case.
+	ConfigFile string
+	// Timeout is the timeout for each git invocation. If zero, there's no
+	// timeout. The git process is killed when the timeout is exceeded.
+	Timeout time.Duration
 }
 
 // FindGitBinary finds a git binary from the PATH.
@@ -104,43 +114,11 @@ func FindGitBinary() (GitBinary, error) {
 
 // ListURLs returns a list of URLs specified for "google" section.
 func (g GitBinary) ListURLs(ctx context.Context) ([]*url.URL, error) {
-	args := append(constructConfigArgs(g), "config", "--name-only", "--list", "--null")
-	cmd := exec.CommandContext(ctx, g.Path, args...)
-	cmd.Stderr = os.Stderr
-	bs, err := cmd.Output()
+	c, err := g.ConfigAll(ctx)
 	if err != nil {
-		return nil, xerrors.Errorf("credentials: cannot get gitconfig: %v", err)
-	}
-
-	m := map[string]bool{}
-	for _, s := range strings.Split(string(bs), "\000") {
-		if !strings.HasPrefix(s, "google.") {
-			continue
-		}
-		s = strings.TrimPrefix(s, "google.")
-		i := strings.LastIndexByte(s, '.')
-		if i > 0 {
-			s = s[:i]
-		}
-		if !strings.Contains(s, "://") {
-			continue
-		}
-		u, err := url.Parse(s)
-		if err != nil {
-			return nil, xerrors.Errorf("credentials: cannot parse the URL %s: %v", s, err)
-		}
-		m[u.String()] = true
-	}
-	urls := []*url.URL{}
-	for rawURL, _ := range m {
-		// The URL should be able to be re-parsed.
-		u, err := url.Parse(rawURL)
-		if err != nil {
-			panic(err)
-		}
-		urls = append(urls, u)
+		return nil, err
 	}
-	return urls, nil
+	return c.URLs()
 }
 
 // ConfigFromGitConfig creates a CredentialConfig from git-config.
@@ -200,17 +178,20 @@ type gitConfigAccessor struct {
 }
 
 func (g gitConfigAccessor) get(ctx context.Context, ty, key string) (string, error) {
-	args := append(constructConfigArgs(g.gitBinary), "config", ty)
+	args := []string{"config"}
+	// Older git has no --no-type, and it's the default there.
+	if ty != "--no-type" || g.gitBinary.supportsNoType(ctx) {
+		args = append(args, ty)
+	}
 	if g.u != nil {
 		args = append(args, "--get-urlmatch", key, g.u.String())
 	} else {
 		args = append(args, key)
 	}
-	cmd := exec.CommandContext(ctx, g.gitBinary.Path, args...)
-	cmd.Stderr = os.Stderr
-	bs, err := cmd.Output()
+	bs, err := g.gitBinary.output(ctx, args...)
 	if err != nil {
-		if ee, ok := err.(*exec.ExitError); ok {
+		var ee *exec.ExitError
+		if xerrors.As(err, &ee) {
 			if ee.ExitCode() == 1 {
 				// The key doesn't exist in the config.
 				return "", nil
@@ -252,6 +233,120 @@ func (g gitConfigAccessor) StringListConfig(ctx context.Context, key string) ([]
 	return ss, nil
 }
 
+// output runs git with the args and returns its stdout. If the context is
+// done or Timeout is exceeded, git and its child processes are killed.
+func (g GitBinary) output(ctx context.Context, args ...string) ([]byte, error) {
+	parent := ctx
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+	var stdout bytes.Buffer
+	stderr := &limitedBuffer{limit: maxGitStderr}
+	configArgs := constructConfigArgs(g)
+	var env []string
+	if g.ConfigFile != "" {
+		if g.supportsConfigGlobal(parent) {
+			env = append(os.Environ(), "GIT_CONFIG_GLOBAL="+g.ConfigFile)
+		} else {
+			// Before the other "-c" so that they override it.
+			configArgs = append([]string{"-c", "include.path=" + g.ConfigFile}, configArgs...)
+		}
+	}
+	cmd := exec.Command(g.Path, append(configArgs, args...)...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	err := runKillable(ctx, cmd)
+	if parent.Err() != nil {
+		return nil, parent.Err()
+	} else if ctx.Err() == context.DeadlineExceeded {
+		return nil, xerrors.Errorf("git timed out after %v", g.Timeout)
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, &GitError{Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	// Pass through the warnings as before.
+	os.Stderr.Write(stderr.Bytes())
+	return stdout.Bytes(), nil
+}
+
+// runKillable runs cmd in its own process group and kills the group when ctx
+// is done. Killing only cmd would leave its child processes, which keep the
+// output pipes open and make Wait hang.
+func runKillable(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	return err
+}
+
+// maxGitStderr is the maximum size of the git stderr kept in a GitError.
+const maxGitStderr = 4096
+
+// GitError is an error from a git invocation with the stderr of git, which
+// usually tells the reason, such as "not a git repository".
+type GitError struct {
+	Err error
+	// The stderr of git, trimmed and truncated to a few kilobytes.
+	Stderr string
+}
+
+func (e *GitError) Error() string {
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Stderr
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest. It doesn't embed bytes.Buffer so that io.Copy cannot bypass Write with
+// ReadFrom.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if rest := b.limit - b.buf.Len(); rest < len(p) {
+		p = p[:rest]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "... (truncated)"
+	}
+	return b.buf.String()
+}
+
 func constructConfigArgs(g GitBinary) []string {
 	args := []string{}
 	for _, c := range g.Configs {
@@ -0,0 +1,78 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestGitErrorHasStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "short",
+			script: `echo 'fatal: not a git repository' >&2; exit 128`,
+			want:   "exit status 128: fatal: not a git repository",
+		},
+		{
+			name:   "long",
+			script: `yes 'fatal: something' | head -c 100000 >&2; exit 128`,
+			want:   "... (truncated)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			git := filepath.Join(dir, "git")
+			if err := ioutil.WriteFile(git, []byte("#!/bin/sh\n"+tc.script+"\n"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			_, err := GitBinary{Path: git}.ConfigAll(context.Background())
+			if err == nil {
+				t.Fatal("ConfigAll succeeded unexpectedly")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("ConfigAll returned %q, want the one with %q", err, tc.want)
+			}
+			if len(err.Error()) > 2*maxGitStderr {
+				t.Errorf("the error is too long: %d bytes", len(err.Error()))
+			}
+			// The callers check the exit code.
+			_, err = GitBinary{Path: git}.output(context.Background(), "config", "--list")
+			var ee *exec.ExitError
+			if !xerrors.As(err, &ee) || ee.ExitCode() != 128 {
+				t.Errorf("output returned %v, want the one with exit status 128", err)
+			}
+		})
+	}
+}
@@ -18,64 +18,118 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 )
 
-// MakeCookies create cookies for .gitcookies.
+// DefaultCookieName is the name of the cookies that Gerrit accepts.
+const DefaultCookieName = "o"
+
+// cookieExpiryMargin is subtracted from the token expiry so that the cookies
+// expire before the token does.
+const cookieExpiryMargin = time.Minute
+
+// MakeCookies create cookies for .gitcookies. The cookies expire
+// cookieExpiryMargin before the token. If the token has no expiry, neither do
+// the cookies. The cookies are named DefaultCookieName.
 func MakeCookies(u *url.URL, token *oauth2.Token) []*http.Cookie {
+	return MakeNamedCookies(u, token, DefaultCookieName)
+}
+
+// MakeNamedCookies is MakeCookies with the given cookie name. Use
+// ValidCookieName to check the name.
+func MakeNamedCookies(u *url.URL, token *oauth2.Token, name string) []*http.Cookie {
 	// N.B. nscjar adds #HttpOnly_ for HttpOnly cookies, and these prevent
 	// git recognize the cookies. Do not add.
-	path := u.Path
-	if path == "" {
-		path = "/"
+	path := CookiePath(u)
+	expires := token.Expiry
+	if !expires.IsZero() {
+		expires = expires.Add(-cookieExpiryMargin)
 	}
-	// The ending ".git" is redundant.
-	path = strings.TrimSuffix(path, ".git")
-	if u.Host == "googlesource.com" {
+	// Cookies are not port specific. The domain must not have the port.
+	host := u.Hostname()
+	if host == "googlesource.com" {
 		// Authenticate against all *.googlesource.com.
 		return []*http.Cookie{
 			{
-				Name:    "o",
+				Name:    name,
 				Value:   token.AccessToken,
 				Path:    path,
-				Domain:  "." + u.Host,
-				Expires: token.Expiry,
+				Domain:  "." + host,
+				Expires: expires,
 				Secure:  u.Scheme == "https",
 			},
 		}
-	} else if strings.HasSuffix(u.Host, ".googlesource.com") {
+	} else if strings.HasSuffix(host, ".googlesource.com") {
 		// Authenticate against both FOO.googlesource.com and
 		// FOO-review.googlesource.com. These two URLs have no
 		// difference.
-		h := strings.TrimSuffix(strings.TrimSuffix(u.Host, ".googlesource.com"), "-review")
+		h := strings.TrimSuffix(strings.TrimSuffix(host, ".googlesource.com"), "-review")
 		return []*http.Cookie{
 			{
-				Name:    "o",
+				Name:    name,
 				Value:   token.AccessToken,
 				Path:    path,
 				Domain:  h + ".googlesource.com",
-				Expires: token.Expiry,
+				Expires: expires,
 				Secure:  u.Scheme == "https",
 			},
 			{
-				Name:    "o",
+				Name:    name,
 				Value:   token.AccessToken,
 				Path:    path,
 				Domain:  h + "-review.googlesource.com",
-				Expires: token.Expiry,
+				Expires: expires,
 				Secure:  u.Scheme == "https",
 			},
 		}
 	}
 	return []*http.Cookie{
 		{
-			Name:    "o",
+			Name:    name,
 			Value:   token.AccessToken,
 			Path:    path,
-			Domain:  u.Host,
-			Expires: token.Expiry,
+			Domain:  host,
+			Expires: expires,
 			Secure:  u.Scheme == "https",
 		},
 	}
 }
+
+// SourceDevelopersCookiePath is the path of the cookies for
+// source.developers.google.com without a path. The repositories are under
+// /p/PROJECT/r/REPO, and some of them don't accept the cookies for "/".
+const SourceDevelopersCookiePath = "/p/"
+
+// CookiePath returns the path of the cookies for u. It's the path of u
+// without the ending ".git", or "/" if u has no path. For
+// source.developers.google.com, the default is SourceDevelopersCookiePath
+// instead.
+func CookiePath(u *url.URL) string {
+	// The cookie paths are matched against the request paths as is, so
+	// keep them escaped.
+	path := u.EscapedPath()
+	if path == "" || path == "/" {
+		if u.Hostname() == "source.developers.google.com" {
+			return SourceDevelopersCookiePath
+		}
+		return "/"
+	}
+	// The ending ".git" is redundant.
+	return strings.TrimSuffix(path, ".git")
+}
+
+// ValidCookieName returns true if name is a token in RFC 6265, that is, it's
+// non-empty and has no control characters, spaces, or separators.
+func ValidCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return true
+}
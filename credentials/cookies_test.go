@@ -0,0 +1,174 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestMakeCookiesExpires(t *testing.T) {
+	expiry := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		url    string
+		expiry time.Time
+		want   time.Time
+	}{
+		{
+			name:   "googlesource.com",
+			url:    "https://googlesource.com",
+			expiry: expiry,
+			want:   expiry.Add(-cookieExpiryMargin),
+		},
+		{
+			name:   "googlesource.com subdomain",
+			url:    "https://gerrit.googlesource.com",
+			expiry: expiry,
+			want:   expiry.Add(-cookieExpiryMargin),
+		},
+		{
+			name:   "other host",
+			url:    "https://source.developers.google.com/p/foo",
+			expiry: expiry,
+			want:   expiry.Add(-cookieExpiryMargin),
+		},
+		{
+			name:   "no expiry",
+			url:    "https://gerrit.googlesource.com",
+			expiry: time.Time{},
+			want:   time.Time{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookies := MakeCookies(u, &oauth2.Token{AccessToken: "token", Expiry: tc.expiry})
+			if len(cookies) == 0 {
+				t.Fatalf("MakeCookies(%s) returned no cookies", tc.url)
+			}
+			for _, c := range cookies {
+				if !c.Expires.Equal(tc.want) {
+					t.Errorf("MakeCookies(%s): %s has Expires %v, want %v", tc.url, c.Domain, c.Expires, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeCookiesPort(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		url  string
+		want []string
+	}{
+		{
+			name: "other host",
+			url:  "https://gerrit.internal:8443/",
+			want: []string{"gerrit.internal"},
+		},
+		{
+			name: "googlesource.com subdomain",
+			url:  "https://gerrit.googlesource.com:443/",
+			want: []string{"gerrit.googlesource.com", "gerrit-review.googlesource.com"},
+		},
+		{
+			name: "googlesource.com",
+			url:  "https://googlesource.com:443",
+			want: []string{".googlesource.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookies := MakeCookies(u, &oauth2.Token{AccessToken: "token"})
+			var got []string
+			for _, c := range cookies {
+				got = append(got, c.Domain)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("MakeCookies(%s) domains = %v, want %v", tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMakeCookiesPath(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		url  string
+		want string
+	}{
+		{
+			name: "no path",
+			url:  "https://gerrit.googlesource.com",
+			want: "/",
+		},
+		{
+			name: "root",
+			url:  "https://gerrit.googlesource.com/",
+			want: "/",
+		},
+		{
+			name: "googlesource.com with a path",
+			url:  "https://googlesource.com/a/",
+			want: "/a/",
+		},
+		{
+			name: "repository",
+			url:  "https://gerrit.googlesource.com/a/foo.git",
+			want: "/a/foo",
+		},
+		{
+			name: "source.developers.google.com",
+			url:  "https://source.developers.google.com",
+			want: SourceDevelopersCookiePath,
+		},
+		{
+			name: "source.developers.google.com repository",
+			url:  "https://source.developers.google.com/p/foo/r/bar",
+			want: "/p/foo/r/bar",
+		},
+		{
+			name: "escaped",
+			url:  "https://gerrit.internal/a/foo%20bar",
+			want: "/a/foo%20bar",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookies := MakeCookies(u, &oauth2.Token{AccessToken: "token"})
+			if len(cookies) == 0 {
+				t.Fatalf("MakeCookies(%s) returned no cookies", tc.url)
+			}
+			for _, c := range cookies {
+				if c.Path != tc.want {
+					t.Errorf("MakeCookies(%s): %s has Path %q, want %q", tc.url, c.Domain, c.Path, tc.want)
+				}
+			}
+		})
+	}
+}
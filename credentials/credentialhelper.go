@@ -0,0 +1,61 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ParseCredentialRequest parses the input of a git credential helper up to
+// the first empty line, and returns the URL of the protocol, host, and path
+// attributes. See https://git-scm.com/docs/git-credential for the format.
+func ParseCredentialRequest(r io.Reader) (*url.URL, error) {
+	sc := bufio.NewScanner(r)
+	u := &url.URL{}
+	for sc.Scan() {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" {
+			break
+		}
+		ss := strings.SplitN(s, "=", 2)
+		if len(ss) != 2 {
+			return nil, xerrors.Errorf("credentials: invalid line: %s", sc.Text())
+		}
+		switch ss[0] {
+		case "protocol":
+			u.Scheme = ss[1]
+		case "host":
+			u.Host = ss[1]
+		case "path":
+			u.Path = ss[1]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, xerrors.Errorf("credentials: %v", err)
+	}
+	return u, nil
+}
+
+// IsCredentialHelperHost returns true if the credential helpers should return
+// the tokens for host. The tokens must not be sent to the other hosts even if
+// the helper is configured for them.
+func IsCredentialHelperHost(host string) bool {
+	return host == "source.developers.google.com" || strings.HasSuffix(host, ".googlesource.com")
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCredentialRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "full",
+			input: "protocol=https\nhost=foo.googlesource.com\npath=bar\n\n",
+			want:  "https://foo.googlesource.com/bar",
+		},
+		{
+			name:  "stops at the empty line",
+			input: "protocol=https\nhost=foo.googlesource.com\n\nhost=example.com\n",
+			want:  "https://foo.googlesource.com",
+		},
+		{
+			name:  "unknown attributes",
+			input: "protocol=https\nhost=foo.googlesource.com\nusername=u\n",
+			want:  "https://foo.googlesource.com",
+		},
+		{
+			name:    "invalid line",
+			input:   "protocol=https\nhost\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := ParseCredentialRequest(strings.NewReader(tc.input))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ParseCredentialRequest(%q) = %v, want an error", tc.input, u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCredentialRequest(%q): %v", tc.input, err)
+			}
+			if got := u.String(); got != tc.want {
+				t.Errorf("ParseCredentialRequest(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsCredentialHelperHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"foo.googlesource.com":         true,
+		"foo-review.googlesource.com":  true,
+		"source.developers.google.com": true,
+		"example.com":                  false,
+		"googlesource.com.example.com": false,
+		"evilgooglesource.com":         false,
+		"":                             false,
+	} {
+		if got := IsCredentialHelperHost(host); got != want {
+			t.Errorf("IsCredentialHelperHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
@@ -17,13 +17,16 @@
 package credentials
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -31,6 +34,7 @@ import (
 	"golang.org/x/oauth2/google"
 	"golang.org/x/xerrors"
 	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/idtoken"
 	"google.golang.org/api/option"
 )
 
@@ -47,13 +51,18 @@ func MakeToken(ctx context.Context, g GitBinary, u *url.URL) (*oauth2.Token, err
 	if err != nil {
 		return nil, xerrors.Errorf("credentials: cannot get configs: %v", err)
 	}
+	return MakeTokenFromConfig(ctx, c)
+}
+
+// MakeTokenFromConfig creates a token based on the given config.
+func MakeTokenFromConfig(ctx context.Context, c *CredentialConfig) (*oauth2.Token, error) {
 	ts, err := TokenSourceFromConfig(ctx, c)
 	if err != nil {
-		return nil, xerrors.Errorf("credentials: cannot get a TokenSource: %v", err)
+		return nil, RedactError(xerrors.Errorf("credentials: cannot get a TokenSource: %w", err))
 	}
 	token, err := ts.Token()
 	if err != nil {
-		return nil, xerrors.Errorf("credentials: cannot get a token: %v", err)
+		return nil, RedactError(xerrors.Errorf("credentials: cannot get a token: %w", err))
 	}
 	return token, nil
 }
@@ -87,26 +96,167 @@ func TokenSourceFromConfig(ctx context.Context, c *CredentialConfig) (oauth2.Tok
 		if err != nil {
 			return nil, xerrors.Errorf("credentials: cannot get the application default credentials: %v", err)
 		}
+		return ImpersonatedTokenSource(ctx, ts, account, c.ServiceAccountDelegateEmails, scopes)
 
-		svc, err := iamcredentials.NewService(ctx, option.WithTokenSource(ts))
-		if err != nil {
-			return nil, xerrors.Errorf("credentials: cannot create an IAM Service Account Credentials API client: %v", err)
+	default:
+		return newGcloudTokenSource(ctx, c, account)
+	}
+}
+
+// ApplicationDefaultTokenSource returns a TokenSource based on the application
+// default credentials, such as ${GOOGLE_APPLICATION_CREDENTIALS} or the GCE
+// metadata server. If scopes is empty, it defaults to the cloud-platform
+// scope.
+func ApplicationDefaultTokenSource(ctx context.Context, scopes []string) (oauth2.TokenSource, error) {
+	return ApplicationDefaultTokenSourceWithTokenURL(ctx, scopes, "")
+}
+
+// ApplicationDefaultTokenSourceWithTokenURL is ApplicationDefaultTokenSource
+// with the given token endpoint instead of Google's. The empty tokenURL means
+// the default one. The token endpoint cannot be changed for the GCE metadata
+// server.
+func ApplicationDefaultTokenSourceWithTokenURL(ctx context.Context, scopes []string, tokenURL string) (oauth2.TokenSource, error) {
+	if len(scopes) == 0 {
+		scopes = []string{scopeCloudPlatform}
+	}
+	creds, err := google.FindDefaultCredentials(ctx, scopes...)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot find the application default credentials: %v", err)
+	}
+	if tokenURL == "" {
+		return creds.TokenSource, nil
+	}
+	if len(creds.JSON) == 0 {
+		return nil, xerrors.Errorf("credentials: the token URL cannot be changed for the application default credentials without a JSON file, such as the GCE metadata server")
+	}
+	var f struct {
+		Type         string `json:"type"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(creds.JSON, &f); err != nil {
+		return nil, xerrors.Errorf("credentials: cannot parse the application default credentials")
+	}
+	switch f.Type {
+	case "service_account":
+		return jwtTokenSource(ctx, creds.JSON, scopes, tokenURL)
+	case "authorized_user":
+		conf := &oauth2.Config{
+			ClientID:     f.ClientID,
+			ClientSecret: f.ClientSecret,
+			Endpoint:     oauth2.Endpoint{AuthURL: google.Endpoint.AuthURL, TokenURL: tokenURL, AuthStyle: oauth2.AuthStyleInParams},
+			Scopes:       scopes,
 		}
+		return conf.TokenSource(ctx, &oauth2.Token{RefreshToken: f.RefreshToken}), nil
+	default:
+		return nil, xerrors.Errorf("credentials: the token URL cannot be changed for the application default credentials of type %q", f.Type)
+	}
+}
+
+// KeyFileTokenSource returns a TokenSource based on the service account JSON
+// key at path. The key file must not be accessible by the group or others
+// except on Windows. If scopes is empty, it defaults to the cloud-platform
+// scope.
+func KeyFileTokenSource(ctx context.Context, path string, scopes []string) (oauth2.TokenSource, error) {
+	return KeyFileTokenSourceWithTokenURL(ctx, path, scopes, "")
+}
+
+// KeyFileTokenSourceWithTokenURL is KeyFileTokenSource with the given token
+// endpoint instead of the one in the key file. The empty tokenURL means the
+// one in the key file.
+func KeyFileTokenSourceWithTokenURL(ctx context.Context, path string, scopes []string, tokenURL string) (oauth2.TokenSource, error) {
+	if len(scopes) == 0 {
+		scopes = []string{scopeCloudPlatform}
+	}
+	bs, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := jwtTokenSource(ctx, bs, scopes, tokenURL)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot parse the key file %s as a service account key", path)
+	}
+	return ts, nil
+}
+
+// jwtTokenSource returns a TokenSource for the service account JSON key. If
+// tokenURL is non-empty, it overrides the token_uri in the key.
+func jwtTokenSource(ctx context.Context, key []byte, scopes []string, tokenURL string) (oauth2.TokenSource, error) {
+	// Do not include the key in the error messages.
+	conf, err := google.JWTConfigFromJSON(key, scopes...)
+	if err != nil {
+		return nil, xerrors.New("credentials: cannot parse the service account key")
+	}
+	if tokenURL != "" {
+		conf.TokenURL = tokenURL
+	}
+	return conf.TokenSource(ctx), nil
+}
 
-		ds := []string{}
-		for _, d := range c.ServiceAccountDelegateEmails {
-			ds = append(ds, fmt.Sprintf("projects/-/serviceAccounts/%s", d))
+// IDTokenSource returns a TokenSource of the ID tokens for the audience. The
+// ID tokens are in AccessToken of the returned tokens. The credentials are
+// taken from the service account JSON key at keyFile, or the application
+// default credentials if keyFile is empty. Only service accounts, including
+// the GCE metadata server, can create ID tokens.
+func IDTokenSource(ctx context.Context, audience, keyFile string) (oauth2.TokenSource, error) {
+	var opts []idtoken.ClientOption
+	if keyFile != "" {
+		bs, err := readKeyFile(keyFile)
+		if err != nil {
+			return nil, err
 		}
-		return oauth2.ReuseTokenSource(nil, &iamCredentialsTokenSource{
-			name:           fmt.Sprintf("projects/-/serviceAccounts/%s", account),
-			delegates:      ds,
-			scopes:         scopes,
-			iamCredService: iamcredentials.NewProjectsServiceAccountsService(svc),
-		}), nil
+		opts = append(opts, option.WithCredentialsJSON(bs))
+	}
+	ts, err := idtoken.NewTokenSource(ctx, audience, opts...)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot create an ID token source for %s: %w", audience, err)
+	}
+	return ts, nil
+}
 
-	default:
-		return newGcloudTokenSource(ctx, c, account)
+// readKeyFile reads the service account JSON key at path. The key file must
+// not be accessible by the group or others except on Windows.
+func readKeyFile(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot read the key file: %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, xerrors.Errorf("credentials: the key file %s is accessible by others (%v). Run chmod 600 on it", path, fi.Mode().Perm())
 	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot read the key file: %v", err)
+	}
+	return bs, nil
+}
+
+// ImpersonatedTokenSource returns a TokenSource for the service account
+// impersonated with the base TokenSource via the IAM Service Account
+// Credentials API. The base TokenSource needs the cloud-platform scope. The
+// delegates are the service account emails in the delegation chain. If scopes
+// is empty, it defaults to the cloud-platform scope. The opts are passed to the
+// API client.
+func ImpersonatedTokenSource(ctx context.Context, base oauth2.TokenSource, account string, delegates, scopes []string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	if len(scopes) == 0 {
+		scopes = []string{scopeCloudPlatform}
+	}
+	svc, err := iamcredentials.NewService(ctx, append([]option.ClientOption{option.WithTokenSource(base)}, opts...)...)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot create an IAM Service Account Credentials API client: %v", err)
+	}
+
+	ds := []string{}
+	for _, d := range delegates {
+		ds = append(ds, fmt.Sprintf("projects/-/serviceAccounts/%s", d))
+	}
+	return oauth2.ReuseTokenSource(nil, &iamCredentialsTokenSource{
+		name:           fmt.Sprintf("projects/-/serviceAccounts/%s", account),
+		delegates:      ds,
+		scopes:         scopes,
+		iamCredService: iamcredentials.NewProjectsServiceAccountsService(svc),
+	}), nil
 }
 
 func newGcloudTokenSource(ctx context.Context, c *CredentialConfig, name string) (oauth2.TokenSource, error) {
@@ -123,12 +273,15 @@ func newGcloudTokenSource(ctx context.Context, c *CredentialConfig, name string)
 		return nil, xerrors.Errorf("credentials: cannot get an absolute path to gcloud: %v", err)
 	}
 	return oauth2.ReuseTokenSource(nil, &gcloudTokenSource{
+		ctx:        ctx,
 		name:       name,
 		gcloudPath: gcloudPath,
 	}), nil
 }
 
 type gcloudTokenSource struct {
+	// gcloud is killed when ctx is done.
+	ctx        context.Context
 	name       string
 	gcloudPath string
 }
@@ -140,19 +293,28 @@ func (s *gcloudTokenSource) Token() (*oauth2.Token, error) {
 	if s.name != "" {
 		ss = append(ss, s.name)
 	}
-	cmd := exec.CommandContext(context.Background(), s.gcloudPath, ss...)
+	var stdout bytes.Buffer
+	cmd := exec.Command(s.gcloudPath, ss...)
+	cmd.Stdout = &stdout
 	cmd.Stderr = os.Stderr
-	bs, err := cmd.Output()
-	if err != nil {
-		return nil, xerrors.Errorf("credentials: failed to run gcloud: %v", err)
+	if err := runKillable(s.ctx, cmd); err != nil {
+		if s.ctx.Err() != nil {
+			err = s.ctx.Err()
+		}
+		return nil, xerrors.Errorf("credentials: failed to run gcloud: %w", err)
 	}
+	bs := stdout.Bytes()
 
 	cred := &gcloudCredential{}
 	if err := json.Unmarshal(bs, cred); err != nil {
 		return nil, xerrors.Errorf("credentials: failed to parse gcloud print-access-token result: %v", err)
 	}
-	if cred.AccessToken == "" || cred.TokenExpiry.DateTime == "" {
-		return nil, xerrors.Errorf("credentials: incomplete gcloud print-access-token result: %v", cred)
+	// Never include cred in the errors. It has the token.
+	if cred.AccessToken == "" {
+		return nil, xerrors.Errorf("credentials: incomplete gcloud print-access-token result: no access_token")
+	}
+	if cred.TokenExpiry.DateTime == "" {
+		return nil, xerrors.Errorf("credentials: incomplete gcloud print-access-token result: no token_expiry")
 	}
 	expiry, err := time.Parse("2006-01-02 15:04:05.000000", cred.TokenExpiry.DateTime)
 	if err != nil {
@@ -184,7 +346,7 @@ func (s *iamCredentialsTokenSource) Token() (*oauth2.Token, error) {
 		Scope:     s.scopes,
 	}).Context(context.Background()).Do()
 	if err != nil {
-		return nil, xerrors.Errorf("credentials: cannot obtain a credential: %v", err)
+		return nil, xerrors.Errorf("credentials: cannot obtain a credential: %w", err)
 	}
 	expiry, err := time.Parse(time.RFC3339Nano, resp.ExpireTime)
 	if err != nil {
@@ -0,0 +1,243 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// GitConfig is a snapshot of git-config read by a single git invocation.
+type GitConfig struct {
+	entries []configEntry
+}
+
+type configEntry struct {
+	// Key as printed by git. The section and the variable names are
+	// lowercased, and the subsection is kept as is.
+	key   string
+	value string
+}
+
+// ConfigAll reads all of git-config, including Configs, at once. Use this
+// instead of the per-key methods to avoid spawning git for each key.
+func (g GitBinary) ConfigAll(ctx context.Context) (*GitConfig, error) {
+	bs, err := g.output(ctx, "config", "--list", "--null")
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot get gitconfig: %v", err)
+	}
+	return &GitConfig{entries: parseConfigList(bs)}, nil
+}
+
+// ConfigFiles returns the absolute paths of the files that git-config is read
+// from, including the included files.
+func (g GitBinary) ConfigFiles(ctx context.Context) ([]string, error) {
+	if !g.supportsShowOrigin(ctx) {
+		v, _ := g.Version(ctx)
+		return nil, xerrors.Errorf("credentials: listing the git-config files needs git 2.8 or newer, got %v", v)
+	}
+	bs, err := g.output(ctx, "config", "--list", "--show-origin", "--null")
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot get gitconfig: %v", err)
+	}
+	files := []string{}
+	top := ""
+	for _, p := range parseConfigOrigins(bs) {
+		// The repository config is relative to the top of the working
+		// tree, not to the current directory.
+		if !filepath.IsAbs(p) {
+			if top == "" {
+				if bs, err := g.output(ctx, "rev-parse", "--show-toplevel"); err == nil {
+					top = strings.TrimSpace(string(bs))
+				}
+			}
+			p = filepath.Join(top, p)
+		}
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return nil, xerrors.Errorf("credentials: cannot get the absolute path of %s: %v", p, err)
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
+
+// parseConfigOrigins parses the output of "git config --list --show-origin
+// --null" and returns the distinct file paths in the order they appear. Each
+// origin such as "file:<path>" is followed by its entry.
+func parseConfigOrigins(bs []byte) []string {
+	seen := map[string]bool{}
+	files := []string{}
+	ss := strings.Split(string(bs), "\000")
+	for i := 0; i+1 < len(ss); i += 2 {
+		if !strings.HasPrefix(ss[i], "file:") {
+			continue
+		}
+		p := strings.TrimPrefix(ss[i], "file:")
+		if !seen[p] {
+			seen[p] = true
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// parseConfigList parses the output of "git config --list --null". Each entry
+// is "<key>\n<value>", or "<key>" if the key has no value.
+func parseConfigList(bs []byte) []configEntry {
+	entries := []configEntry{}
+	for _, s := range strings.Split(string(bs), "\000") {
+		if s == "" {
+			continue
+		}
+		ss := strings.SplitN(s, "\n", 2)
+		e := configEntry{key: ss[0]}
+		if len(ss) == 2 {
+			e.value = ss[1]
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// URLs returns a list of URLs specified for "google" section, sorted.
+func (c *GitConfig) URLs() ([]*url.URL, error) {
+	m := map[string]*url.URL{}
+	for _, e := range c.entries {
+		if !strings.HasPrefix(e.key, "google.") {
+			continue
+		}
+		s := strings.TrimPrefix(e.key, "google.")
+		i := strings.LastIndexByte(s, '.')
+		if i > 0 {
+			s = s[:i]
+		}
+		if !strings.Contains(s, "://") {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, xerrors.Errorf("credentials: cannot parse the URL %s: %v", s, err)
+		}
+		m[u.String()] = u
+	}
+	urls := []*url.URL{}
+	for _, u := range m {
+		urls = append(urls, u)
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].String() < urls[j].String() })
+	return urls, nil
+}
+
+// StringConfig returns the last value of the key as git does. It returns an
+// empty string if the key doesn't exist.
+func (c *GitConfig) StringConfig(key string) string {
+	key = canonicalConfigKey(key)
+	v := ""
+	for _, e := range c.entries {
+		if e.key == key {
+			v = e.value
+		}
+	}
+	return v
+}
+
+// PathConfig returns the last value of the key as a path. A leading "~/" or
+// "~user/" is expanded as "git config --path" does.
+func (c *GitConfig) PathConfig(key string) (string, error) {
+	return expandPath(c.StringConfig(key))
+}
+
+// HasCredentials returns false if no account in the config can get a token,
+// that is, if all the accounts need gcloud and gcloud cannot be found. This is
+// a cheap check and doesn't mean that a token can be created.
+func (c *GitConfig) HasCredentials() bool {
+	for _, e := range c.entries {
+		if !strings.HasPrefix(e.key, "google.") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(e.key, ".account"):
+			if AccountHasCredentials(e.value) {
+				return true
+			}
+		case strings.HasSuffix(e.key, ".gcloudpath"):
+			if p, err := expandPath(e.value); err == nil {
+				if _, err := os.Stat(p); err == nil {
+					return true
+				}
+			}
+		}
+	}
+	_, err := exec.LookPath("gcloud")
+	return err == nil
+}
+
+// AccountHasCredentials returns true if the account in google.account can
+// create the tokens without gcloud, such as application-default and the
+// service accounts.
+func AccountHasCredentials(account string) bool {
+	return account == accountApplicationDefault || strings.HasSuffix(account, ".gserviceaccount.com")
+}
+
+// expandPath expands a leading "~/" or "~user/" in p.
+func expandPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+	name, rest := p[1:], ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, rest = name[:i], name[i+1:]
+	}
+	var home string
+	if name == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", xerrors.Errorf("credentials: cannot expand %s: %v", p, err)
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", xerrors.Errorf("credentials: cannot expand %s: %v", p, err)
+		}
+		home = u.HomeDir
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// URLRewriter returns the URL rewrite rules in the config.
+func (c *GitConfig) URLRewriter() *URLRewriter {
+	return newURLRewriter(c.entries)
+}
+
+// canonicalConfigKey lowercases the section and the variable names of key as
+// git prints them.
+func canonicalConfigKey(key string) string {
+	i := strings.IndexByte(key, '.')
+	j := strings.LastIndexByte(key, '.')
+	if i < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:i]) + key[i:j] + strings.ToLower(key[j:])
+}
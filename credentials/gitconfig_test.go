@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitConfig(t *testing.T) {
+	c := &GitConfig{entries: parseConfigList([]byte(strings.Join([]string{
+		"core.bare\nfalse",
+		"google.cookiefile\n/tmp/old",
+		"google.https://b.googlesource.com.account\nfoo@example.com",
+		"google.https://a.googlesource.com/repo.scopes\nscope",
+		"google.https://b.googlesource.com.scopes\nscope",
+		"google.cookiefile\n~/cookies",
+		"google.noValue",
+		"url.https://a.googlesource.com/.insteadof\nhttps://a.example.com/",
+	}, "\000") + "\000"))}
+
+	urls, err := c.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, u := range urls {
+		got = append(got, u.String())
+	}
+	want := []string{"https://a.googlesource.com/repo", "https://b.googlesource.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URLs() = %v, want %v", got, want)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	p, err := c.PathConfig("google.cookieFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(home, "cookies"); p != want {
+		t.Errorf("PathConfig(google.cookieFile) = %q, want %q", p, want)
+	}
+	if v := c.StringConfig("google.missing"); v != "" {
+		t.Errorf("StringConfig(google.missing) = %q, want empty", v)
+	}
+	if _, ok := c.URLRewriter().insteadOf["https://a.example.com/"]; !ok {
+		t.Errorf("URLRewriter() doesn't have the insteadOf rule")
+	}
+}
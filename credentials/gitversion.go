@@ -0,0 +1,118 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// GitVersion is the version of a git binary, such as 2.39.2.
+type GitVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v GitVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns true if v is major.minor.patch or newer.
+func (v GitVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// gitVersions caches the versions by the git binary paths, so that git is
+// invoked once for each binary.
+var gitVersions sync.Map
+
+// Version returns the version of the git binary from "git --version".
+func (g GitBinary) Version(ctx context.Context) (GitVersion, error) {
+	if v, ok := gitVersions.Load(g.Path); ok {
+		return v.(GitVersion), nil
+	}
+	// The -c configs are irrelevant to the version.
+	bs, err := GitBinary{Path: g.Path, Timeout: g.Timeout}.output(ctx, "--version")
+	if err != nil {
+		return GitVersion{}, xerrors.Errorf("credentials: cannot get the git version: %v", err)
+	}
+	v, err := parseGitVersion(string(bs))
+	if err != nil {
+		return GitVersion{}, err
+	}
+	gitVersions.Store(g.Path, v)
+	return v, nil
+}
+
+// parseGitVersion parses the output of "git --version", such as "git version
+// 2.39.2", "git version 2.24.3 (Apple Git-128)", and "git version
+// 2.40.0.windows.1". The missing parts are zero.
+func parseGitVersion(s string) (GitVersion, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return GitVersion{}, xerrors.Errorf("credentials: cannot parse the git version: %q", strings.TrimSpace(s))
+	}
+	var v GitVersion
+	parts := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range strings.SplitN(fields[2], ".", 4) {
+		if i >= len(parts) {
+			break
+		}
+		// Such as "0-rc1".
+		if j := strings.IndexFunc(p, func(r rune) bool { return r < '0' || r > '9' }); j >= 0 {
+			p = p[:j]
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			if i == 0 {
+				return GitVersion{}, xerrors.Errorf("credentials: cannot parse the git version: %q", strings.TrimSpace(s))
+			}
+			break
+		}
+		*parts[i] = n
+	}
+	return v, nil
+}
+
+// supportsNoType returns true if git-config takes --no-type, which git 2.18
+// added. If the version is unknown, it assumes a recent git.
+func (g GitBinary) supportsNoType(ctx context.Context) bool {
+	v, err := g.Version(ctx)
+	return err != nil || v.AtLeast(2, 18, 0)
+}
+
+// supportsShowOrigin returns true if git-config takes --show-origin, which
+// git 2.8 added. If the version is unknown, it assumes a recent git.
+func (g GitBinary) supportsShowOrigin(ctx context.Context) bool {
+	v, err := g.Version(ctx)
+	return err != nil || v.AtLeast(2, 8, 0)
+}
+
+// supportsConfigGlobal returns true if git reads ${GIT_CONFIG_GLOBAL}, which
+// git 2.32 added. If the version is unknown, it assumes a recent git.
+func (g GitBinary) supportsConfigGlobal(ctx context.Context) bool {
+	v, err := g.Version(ctx)
+	return err != nil || v.AtLeast(2, 32, 0)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"testing"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		s       string
+		want    GitVersion
+		wantErr bool
+	}{
+		{
+			name: "linux",
+			s:    "git version 2.39.2\n",
+			want: GitVersion{2, 39, 2},
+		},
+		{
+			name: "apple",
+			s:    "git version 2.24.3 (Apple Git-128)\n",
+			want: GitVersion{2, 24, 3},
+		},
+		{
+			name: "windows",
+			s:    "git version 2.40.0.windows.1\n",
+			want: GitVersion{2, 40, 0},
+		},
+		{
+			name: "release candidate",
+			s:    "git version 2.41.0-rc1\n",
+			want: GitVersion{2, 41, 0},
+		},
+		{
+			name: "no patch",
+			s:    "git version 1.8\n",
+			want: GitVersion{1, 8, 0},
+		},
+		{
+			name:    "not git",
+			s:       "hg version 6.0\n",
+			wantErr: true,
+		},
+		{
+			name:    "no number",
+			s:       "git version unknown\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseGitVersion(tc.s)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("parseGitVersion(%q) = %v, want an error", tc.s, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGitVersion(%q) returned %v", tc.s, err)
+			}
+			if got != tc.want {
+				t.Errorf("parseGitVersion(%q) = %v, want %v", tc.s, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGitVersionAtLeast(t *testing.T) {
+	v := GitVersion{2, 18, 1}
+	for _, tc := range []struct {
+		major, minor, patch int
+		want                bool
+	}{
+		{2, 18, 0, true},
+		{2, 18, 1, true},
+		{2, 18, 2, false},
+		{2, 8, 0, true},
+		{3, 0, 0, false},
+		{1, 99, 99, true},
+	} {
+		if got := v.AtLeast(tc.major, tc.minor, tc.patch); got != tc.want {
+			t.Errorf("%v.AtLeast(%d, %d, %d) = %v, want %v", v, tc.major, tc.minor, tc.patch, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"regexp"
+)
+
+// tokenPattern matches the Google OAuth2 access tokens ("ya29.") and refresh
+// tokens ("1//"), and the JWTs such as the ID tokens. A JWT is three base64url
+// segments, and the first one starts with "eyJ" for `{"`.
+var tokenPattern = regexp.MustCompile(`\b(?:(ya29\.|1//)[0-9A-Za-z_.\-]+|(eyJ)[0-9A-Za-z_\-]+\.[0-9A-Za-z_\-]+\.[0-9A-Za-z_\-]*)`)
+
+// Redact replaces the token-like substrings in s.
+func Redact(s string) string {
+	return tokenPattern.ReplaceAllString(s, "${1}${2}REDACTED")
+}
+
+// RedactError returns an error whose message has no token-like substrings.
+// The returned error wraps err, so xerrors.Is and xerrors.As still see err.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// ListSubmoduleURLs returns the HTTP(S) URLs of the submodules in .gitmodules
+// of the repository in the current directory. Relative URLs are resolved
+// against remote.origin.url. Other URLs, such as SSH ones, are skipped.
+func (g GitBinary) ListSubmoduleURLs(ctx context.Context) ([]*url.URL, error) {
+	bs, err := g.output(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot find the repository: %v", err)
+	}
+	gitmodules := filepath.Join(strings.TrimSpace(string(bs)), ".gitmodules")
+	bs, err = g.output(ctx, "config", "--file", gitmodules, "--null", "--get-regexp", `^submodule\..*\.url$`)
+	if err != nil {
+		var ee *exec.ExitError
+		if xerrors.As(err, &ee) && ee.ExitCode() == 1 {
+			// No submodules.
+			return nil, nil
+		}
+		return nil, xerrors.Errorf("credentials: cannot read %s: %v", gitmodules, err)
+	}
+	origin, err := g.StringConfig(ctx, "remote.origin.url")
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []*url.URL{}
+	for _, entry := range strings.Split(string(bs), "\000") {
+		ss := strings.SplitN(entry, "\n", 2)
+		if len(ss) != 2 {
+			continue
+		}
+		raw := ss[1]
+		if strings.HasPrefix(raw, "./") || strings.HasPrefix(raw, "../") {
+			if origin == "" {
+				continue
+			}
+			raw = resolveRelativeURL(origin, raw)
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			continue
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// resolveRelativeURL resolves a relative submodule URL against the
+// superproject URL as git does. The last path component of base is treated as
+// a directory.
+func resolveRelativeURL(base, rel string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return rel
+	}
+	u.Path = path.Join(u.Path, rel)
+	return u.String()
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSource creates a token for a URL. Implement this to take the tokens
+// from somewhere other than git-config, such as a secret store.
+type TokenSource interface {
+	TokenForURL(ctx context.Context, u *url.URL) (*oauth2.Token, error)
+}
+
+// TokenSourceFunc is an adapter to use a function as a TokenSource.
+type TokenSourceFunc func(ctx context.Context, u *url.URL) (*oauth2.Token, error)
+
+// TokenForURL calls f(ctx, u).
+func (f TokenSourceFunc) TokenForURL(ctx context.Context, u *url.URL) (*oauth2.Token, error) {
+	return f(ctx, u)
+}
+
+// GitConfigTokenSource returns the default TokenSource, which creates the
+// tokens from the credentials configured in git-config as MakeToken does.
+func GitConfigTokenSource(g GitBinary) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context, u *url.URL) (*oauth2.Token, error) {
+		return MakeToken(ctx, g, u)
+	})
+}
+
+// OAuth2TokenSource returns a TokenSource that takes the tokens for all URLs
+// from ts.
+func OAuth2TokenSource(ts oauth2.TokenSource) TokenSource {
+	return TokenSourceFunc(func(context.Context, *url.URL) (*oauth2.Token, error) {
+		return ts.Token()
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// URLRewriter rewrites URLs based on url.<base>.insteadOf and
+// url.<base>.pushInsteadOf in git-config.
+type URLRewriter struct {
+	// Maps from a prefix to its replacement base.
+	insteadOf     map[string]string
+	pushInsteadOf map[string]string
+}
+
+// URLRewriter reads the URL rewrite rules from git-config.
+func (g GitBinary) URLRewriter(ctx context.Context) (*URLRewriter, error) {
+	c, err := g.ConfigAll(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("credentials: cannot get the URL rewrite rules: %v", err)
+	}
+	return c.URLRewriter(), nil
+}
+
+// parseURLRewrites parses the output of "git config --null --get-regexp" or
+// "git config --null --list".
+func parseURLRewrites(bs []byte) *URLRewriter {
+	return newURLRewriter(parseConfigList(bs))
+}
+
+// newURLRewriter collects the rewrite rules from the config entries. Each rule
+// is "url.<base>.insteadof" with the prefix as the value.
+func newURLRewriter(entries []configEntry) *URLRewriter {
+	r := &URLRewriter{
+		insteadOf:     map[string]string{},
+		pushInsteadOf: map[string]string{},
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.key, "url.") || e.value == "" {
+			continue
+		}
+		key, prefix := strings.TrimPrefix(e.key, "url."), e.value
+		// The section and the variable names are case-insensitive, and git
+		// lowercases them. The base URL is kept as is.
+		switch lower := strings.ToLower(key); {
+		case strings.HasSuffix(lower, ".pushinsteadof"):
+			r.pushInsteadOf[prefix] = key[:len(key)-len(".pushinsteadof")]
+		case strings.HasSuffix(lower, ".insteadof"):
+			r.insteadOf[prefix] = key[:len(key)-len(".insteadof")]
+		}
+	}
+	return r
+}
+
+// Rewrite returns the URLs that git actually contacts for u. The first one is
+// the fetch URL. The push URL follows if it's different.
+func (r *URLRewriter) Rewrite(u *url.URL) ([]*url.URL, error) {
+	fetch, _ := r.rewrite(r.insteadOf, u)
+	raws := []string{fetch}
+	if push, ok := r.rewrite(r.pushInsteadOf, u); ok && push != fetch {
+		raws = append(raws, push)
+	}
+	urls := []*url.URL{}
+	for _, raw := range raws {
+		ru, err := url.Parse(raw)
+		if err != nil {
+			return nil, xerrors.Errorf("credentials: cannot parse the rewritten URL %s: %v", raw, err)
+		}
+		urls = append(urls, ru)
+	}
+	return urls, nil
+}
+
+// rewrite rewrites u with the rules. The URLs in git-config usually have no
+// trailing slash for the host root, while the prefixes usually have one, so
+// the root is matched with a trailing slash as well.
+func (r *URLRewriter) rewrite(rules map[string]string, u *url.URL) (string, bool) {
+	s := u.String()
+	if rewritten, ok := rewriteLongestPrefix(rules, s); ok {
+		return rewritten, true
+	}
+	if u.Path == "" && u.RawQuery == "" && u.Fragment == "" {
+		if rewritten, ok := rewriteLongestPrefix(rules, s+"/"); ok {
+			return rewritten, true
+		}
+	}
+	return s, false
+}
+
+// rewriteLongestPrefix replaces the longest matching prefix as git does. It
+// returns false if no rule matches.
+func rewriteLongestPrefix(rules map[string]string, s string) (string, bool) {
+	best := ""
+	found := false
+	for prefix := range rules {
+		if strings.HasPrefix(s, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	if !found {
+		return s, false
+	}
+	return rules[best] + strings.TrimPrefix(s, best), true
+}
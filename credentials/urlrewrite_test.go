@@ -0,0 +1,100 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLRewriterRewrite(t *testing.T) {
+	config := strings.Join([]string{
+		"url.https://chromium.googlesource.com/.insteadof\nhttps://chromium.example.com/",
+		"url.https://Gerrit.GoogleSource.com/a/.insteadof\ngs:",
+		"url.https://other.googlesource.com/.insteadof\nhttps://chromium.example.com/special/",
+		"url.https://push.googlesource.com/.pushinsteadof\nhttps://review.example.com/",
+		"url.https://fetch.googlesource.com/.insteadof\nhttps://review.example.com/",
+	}, "\000") + "\000"
+	r := parseURLRewrites([]byte(config))
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		want []string
+	}{
+		{
+			name: "no rewrite",
+			url:  "https://googlesource.com",
+			want: []string{"https://googlesource.com"},
+		},
+		{
+			name: "host change",
+			url:  "https://chromium.example.com/src",
+			want: []string{"https://chromium.googlesource.com/src"},
+		},
+		{
+			name: "host root without a trailing slash",
+			url:  "https://chromium.example.com",
+			want: []string{"https://chromium.googlesource.com/"},
+		},
+		{
+			name: "longest prefix wins",
+			url:  "https://chromium.example.com/special/repo",
+			want: []string{"https://other.googlesource.com/repo"},
+		},
+		{
+			name: "scheme shorthand keeps the base case",
+			url:  "gs:foo",
+			want: []string{"https://Gerrit.GoogleSource.com/a/foo"},
+		},
+		{
+			name: "push rewrite",
+			url:  "https://review.example.com/repo",
+			want: []string{"https://fetch.googlesource.com/repo", "https://push.googlesource.com/repo"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			urls, err := r.Rewrite(u)
+			if err != nil {
+				t.Fatalf("Rewrite(%s): %v", tc.url, err)
+			}
+			got := []string{}
+			for _, u := range urls {
+				got = append(got, u.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Rewrite(%s) = %v, want %v", tc.url, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseURLRewritesEmpty(t *testing.T) {
+	r := parseURLRewrites(nil)
+	u, _ := url.Parse("https://googlesource.com/foo")
+	urls, err := r.Rewrite(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].String() != u.String() {
+		t.Errorf("Rewrite(%s) = %v, want unchanged", u, urls)
+	}
+}
@@ -18,13 +18,10 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
-	"strings"
 
 	"github.com/google/googlesource-auth-tools/credentials"
 )
@@ -38,38 +35,14 @@ func main() {
 		return
 	}
 
-	sc := bufio.NewScanner(os.Stdin)
-	var protocol, host, path string
-	for sc.Scan() {
-		s := strings.TrimSpace(sc.Text())
-		if s == "" {
-			break
-		}
-		ss := strings.SplitN(s, "=", 2)
-		if len(ss) != 2 {
-			log.Fatalf("Cannot parse the git-credential input: %s", sc.Text())
-		}
-		switch ss[0] {
-		case "protocol":
-			protocol = ss[1]
-		case "host":
-			host = ss[1]
-		case "path":
-			path = ss[1]
-		}
-	}
-	if err := sc.Err(); err != nil {
+	u, err := credentials.ParseCredentialRequest(os.Stdin)
+	if err != nil {
 		log.Fatalf("Cannot parse the git-credential input: %v", err)
 	}
-
-	if host != "source.developers.google.com" && !strings.HasSuffix(host, ".googlesource.com") {
+	if !credentials.IsCredentialHelperHost(u.Host) {
 		return
 	}
-
-	u := &url.URL{}
-	u.Scheme = protocol
-	u.Host = host
-	u.Path = path
+	protocol, host := u.Scheme, u.Host
 
 	switch protocol {
 	case "https":
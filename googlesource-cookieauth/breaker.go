@@ -0,0 +1,104 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/xerrors"
+)
+
+// hostBreaker stops trying the hosts that keep failing for a while, so that
+// they don't flood the logs and the token endpoints. After threshold
+// consecutive failures, a host is skipped for the base back-off, and the
+// back-off doubles on each failure after that up to max. The first success
+// resets the host.
+type hostBreaker struct {
+	threshold int
+	base, max time.Duration
+	hosts     map[string]*hostState
+}
+
+type hostState struct {
+	failures int
+	// The host is skipped until this time.
+	until time.Time
+}
+
+func newHostBreaker(threshold int, base, max time.Duration) *hostBreaker {
+	return &hostBreaker{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		hosts:     map[string]*hostState{},
+	}
+}
+
+// skipped returns the hosts to skip at now, sorted.
+func (b *hostBreaker) skipped(now time.Time) []string {
+	hosts := []string{}
+	for h, s := range b.hosts {
+		if now.Before(s.until) {
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// record updates the hosts with the result of cookieauth.WriteCookies.
+func (b *hostBreaker) record(res *cookieauth.Result, err error, now time.Time) {
+	if b.threshold <= 0 {
+		return
+	}
+	if res != nil {
+		for _, h := range res.Hosts {
+			if s, ok := b.hosts[h]; ok && s.failures >= b.threshold {
+				logEvent(levelInfo, logFields{"host": h, "count": s.failures}, "%s recovered after %d consecutive failures", h, s.failures)
+			}
+			delete(b.hosts, h)
+		}
+	}
+	var errs cookieauth.HostErrors
+	if !xerrors.As(err, &errs) {
+		return
+	}
+	for _, e := range errs {
+		var he *cookieauth.HostError
+		if !xerrors.As(e, &he) {
+			continue
+		}
+		s, ok := b.hosts[he.Host]
+		if !ok {
+			s = &hostState{}
+			b.hosts[he.Host] = s
+		}
+		s.failures++
+		if s.failures < b.threshold {
+			continue
+		}
+		backoff := b.base
+		for i := b.threshold; i < s.failures && backoff < b.max; i++ {
+			backoff *= 2
+		}
+		if backoff > b.max {
+			backoff = b.max
+		}
+		s.until = now.Add(backoff)
+		logEvent(levelWarning, logFields{"host": he.Host, "count": s.failures, "duration": backoff, "error": he.Err}, "Skipping %s for %v after %d consecutive failures", he.Host, backoff, s.failures)
+	}
+}
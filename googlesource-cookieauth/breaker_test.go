@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/xerrors"
+)
+
+func TestHostBreaker(t *testing.T) {
+	b := newHostBreaker(2, time.Minute, 3*time.Minute)
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	fail := func(host string) error {
+		return cookieauth.HostErrors{&cookieauth.HostError{Host: host, Err: xerrors.New("failed")}}
+	}
+	ok := &cookieauth.Result{Hosts: []string{"good.example.com"}}
+
+	for _, step := range []struct {
+		name string
+		res  *cookieauth.Result
+		err  error
+		// Time since the previous step.
+		after time.Duration
+		want  []string
+	}{
+		{
+			name: "first failure",
+			res:  ok,
+			err:  fail("bad.example.com"),
+			want: []string{},
+		},
+		{
+			name: "threshold",
+			res:  ok,
+			err:  fail("bad.example.com"),
+			want: []string{"bad.example.com"},
+		},
+		{
+			name:  "base back-off passed",
+			after: time.Minute,
+			res:   ok,
+			want:  []string{},
+		},
+		{
+			name: "doubled",
+			res:  ok,
+			err:  fail("bad.example.com"),
+			want: []string{"bad.example.com"},
+		},
+		{
+			name:  "still skipped",
+			after: time.Minute,
+			res:   ok,
+			want:  []string{"bad.example.com"},
+		},
+		{
+			name:  "capped",
+			after: time.Minute,
+			// All hosts failed.
+			err:  xerrors.Errorf("cannot create a token for any host: %w", fail("bad.example.com")),
+			want: []string{"bad.example.com"},
+		},
+		{
+			name:  "cap passed",
+			after: 3 * time.Minute,
+			want:  []string{},
+		},
+		{
+			name: "recovered",
+			res:  &cookieauth.Result{Hosts: []string{"bad.example.com"}},
+			want: []string{},
+		},
+		{
+			name: "reset",
+			err:  fail("bad.example.com"),
+			want: []string{},
+		},
+	} {
+		now = now.Add(step.after)
+		b.record(step.res, step.err, now)
+		if got := b.skipped(now); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: skipped() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
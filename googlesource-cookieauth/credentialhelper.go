@@ -0,0 +1,84 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"github.com/google/googlesource-auth-tools/credentials"
+)
+
+// runCredentialHelper implements the git-credential helper protocol. See
+// https://git-scm.com/docs/git-credential for the protocol. Only "get" is
+// supported; "store" and "erase" are no-op. Like git-credential-googlesource,
+// it returns nothing for the hosts other than *.googlesource.com and
+// source.developers.google.com.
+func runCredentialHelper(ctx context.Context, opts *cookieauth.Options, op string, r io.Reader, w io.Writer) error {
+	switch op {
+	case "get":
+	case "store", "erase":
+		return nil
+	default:
+		return fmt.Errorf("unknown credential helper operation: %s", op)
+	}
+
+	u, err := credentials.ParseCredentialRequest(r)
+	if err != nil {
+		return fmt.Errorf("cannot parse the git-credential input: %v", err)
+	}
+	// Print nothing so that git tries the next helper.
+	if !credentials.IsCredentialHelperHost(u.Host) {
+		return nil
+	}
+	protocol, host := u.Scheme, u.Host
+
+	gitBinary, err := opts.GitBinary()
+	if err != nil {
+		return err
+	}
+
+	switch protocol {
+	case "https":
+		// OK
+	case "http":
+		allowHTTP, err := gitBinary.WithURL(u).BoolConfig(ctx, "google.allowHTTPForCredentialHelper")
+		if err != nil {
+			return fmt.Errorf("cannot get a config for google.allowHTTPForCredentialHelper: %v", err)
+		}
+		if !allowHTTP {
+			return fmt.Errorf("HTTP protocol is not allowed without google.allowHTTPForCredentialHelper")
+		}
+	default:
+		return fmt.Errorf("unknown protocol: %s", protocol)
+	}
+
+	token, err := opts.MakeToken(ctx, gitBinary, u)
+	if err != nil {
+		return fmt.Errorf("cannot get a token: %v", err)
+	}
+
+	fmt.Fprintf(w, "protocol=%s\n", protocol)
+	fmt.Fprintf(w, "host=%s\n", host)
+	username := opts.Username
+	if username == "" {
+		username = cookieauth.DefaultUsername
+	}
+	fmt.Fprintf(w, "username=%s\n", username)
+	fmt.Fprintf(w, "password=%s\n", token.AccessToken)
+	return nil
+}
@@ -0,0 +1,338 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/xerrors"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// failureError is returned by runDaemon when it stops after
+// -max-consecutive-failures failed refreshes. It wraps the last failure.
+type failureError struct {
+	failures int
+	err      error
+}
+
+func (e *failureError) Error() string {
+	return fmt.Sprintf("%d consecutive failures: %v", e.failures, e.err)
+}
+
+func (e *failureError) Unwrap() error {
+	return e.err
+}
+
+// runDaemon refreshes the cookies until ctx is done. It returns an error if it
+// cannot start, or a *failureError if the refreshes keep failing.
+func runDaemon(ctx context.Context, opts *cookieauth.Options) error {
+	if *refreshInterval < minRefreshInterval {
+		return fmt.Errorf("-refresh-interval must be at least %v, got %v", minRefreshInterval, *refreshInterval)
+	}
+	if *minRefresh <= 0 {
+		return fmt.Errorf("-min-refresh-interval must be positive, got %v", *minRefresh)
+	}
+	if *maxRefresh != 0 && *maxRefresh < *minRefresh {
+		return fmt.Errorf("-max-refresh-interval must be at least -min-refresh-interval %v, got %v", *minRefresh, *maxRefresh)
+	}
+	if *maxConsecutiveFailures < 0 {
+		return fmt.Errorf("-max-consecutive-failures must not be negative, got %d", *maxConsecutiveFailures)
+	}
+	if *hostFailureThreshold < 0 {
+		return fmt.Errorf("-host-failure-threshold must not be negative, got %d", *hostFailureThreshold)
+	}
+	if *refreshJitter < 0 || *refreshJitter >= 100 {
+		return fmt.Errorf("-refresh-jitter must be in [0, 100), got %v", *refreshJitter)
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// With -once, stop is called after the first successful refresh.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	if *pidFile != "" {
+		release, err := acquirePIDFile(*pidFile)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	m := newMetrics()
+	m.setWaiting(*waitForReady)
+	breaker := newHostBreaker(*hostFailureThreshold, *refreshInterval, *hostMaxBackoff)
+	muxes := map[string]*http.ServeMux{}
+	handle := func(addr, path string, h http.Handler) {
+		if addr == "" {
+			return
+		}
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(path, h)
+	}
+	handle(*metricsAddr, "/metrics", m)
+	handle(*healthAddr, "/healthz", &healthHandler{m: m, staleness: *healthStaleness})
+	for addr, mux := range muxes {
+		stop, err := serveHTTP(addr, mux)
+		if err != nil {
+			return fmt.Errorf("cannot start the HTTP server on %s: %v", addr, err)
+		}
+		defer stop()
+	}
+
+	// SIGHUP and the git-config changes force a refresh. The refreshes run
+	// in refreshLoop's goroutine, so they never overlap, and the triggers
+	// received during a refresh are coalesced into one.
+	force := make(chan string, 1)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-hup:
+				select {
+				case force <- fmt.Sprintf("Received %v", sig):
+				default:
+				}
+			}
+		}
+	}()
+	if *watchConfig {
+		gitBinary, err := opts.GitBinary()
+		if err != nil {
+			return err
+		}
+		cw, err := newConfigWatcher(ctx, gitBinary)
+		if err != nil {
+			return fmt.Errorf("cannot watch the git-config files: %v", err)
+		}
+		go cw.run(ctx, force)
+	}
+
+	// The setup above may need the privileges, such as for the ports and
+	// the PID file. The refreshes don't.
+	if *runAsUser != "" {
+		if err := dropPrivileges(*runAsUser); err != nil {
+			return fmt.Errorf("cannot drop the privileges: %v", err)
+		}
+	}
+
+	// See countFailures.
+	failures := 0
+	var failed error
+	status := &daemonStatus{}
+	// True if the last wait was zero for the expired tokens.
+	refreshedNow := false
+	// See http://man7.org/linux/man-pages/man7/daemon.7.html for
+	// the new style daemons.
+	refreshLoop(ctx, func() time.Duration {
+		next := *refreshInterval
+		start := time.Now()
+		rctx, cancel := withTimeout(ctx, *timeout)
+		o := *opts
+		o.SkipHosts = append(append([]string{}, opts.SkipHosts...), breaker.skipped(start)...)
+		res, err := cookieauth.WriteCookies(rctx, &o)
+		cancel()
+		if *debugDumpPath != "" {
+			if err := writeDebugDump(ctx, *debugDumpPath, &o, res, err); err != nil {
+				log.Printf("Cannot write the debug dump: %v", err)
+			}
+		}
+		elapsed := time.Since(start)
+		breaker.record(res, err, time.Now())
+		m.setSkippedHosts(breaker.skipped(time.Now()))
+		if res != nil {
+			next = nextRefresh(res.Expiry, time.Now())
+		}
+		if m.isWaiting() && err != nil {
+			// Retry soon so that the dependents don't wait for long.
+			next = *minRefresh
+		}
+		next = scheduleRefresh(jitter(next, *refreshJitter, rnd), *minRefresh, *maxRefresh, refreshedNow)
+		refreshedNow = next == 0
+		m.observe(elapsed, res, err, next)
+		if *statusFile != "" {
+			status.record(res, err, time.Now(), next)
+			if err := writeStatusFile(*statusFile, status); err != nil {
+				log.Printf("Cannot write the status file: %v", err)
+			}
+		}
+		failures = countFailures(failures, err)
+		if errs, ok := err.(cookieauth.HostErrors); ok {
+			f := resultFields(res, errs)
+			f["duration"] = next
+			logEvent(levelWarning, f, "Wrote %v except for the failed hosts: %v. Next refresh in %v", res, errs, next)
+		} else if xerrors.Is(err, cookieauth.ErrNoHosts) {
+			// This won't fix itself unless git-config changes.
+			logEvent(levelError, logFields{"error": err, "duration": next}, "No hosts to write cookies for. The configuration is wrong. Next refresh in %v", next)
+			logHint(err)
+		} else if err != nil {
+			logEvent(levelError, logFields{"error": err, "duration": next}, "Cannot write cookies: %v. Next refresh in %v", err, next)
+			logHint(err)
+		} else {
+			f := resultFields(res, nil)
+			f["duration"] = next
+			logEvent(levelInfo, f, "Wrote %v. Next refresh in %v", res, next)
+			if m.isWaiting() {
+				m.setWaiting(false)
+				log.Printf("Ready after the first successful refresh")
+				if err := notifyReady(); err != nil {
+					log.Printf("Cannot notify the service manager: %v", err)
+				}
+			}
+			if *once {
+				log.Printf("Stopping after the first successful refresh because of -once")
+				stop()
+			}
+		}
+		if *maxConsecutiveFailures > 0 && failures >= *maxConsecutiveFailures {
+			failed = &failureError{failures: failures, err: err}
+			stop()
+		}
+		return next
+	}, force, newRealTimer)
+	log.Printf("Shutting down")
+	return failed
+}
+
+// countFailures returns the number of the consecutive failures after a
+// refresh that returned err. Only the refreshes that write nothing count, and
+// a success resets it. The refreshes where hostBreaker skips all hosts don't
+// change it because they're the back-off of the earlier failures.
+func countFailures(failures int, err error) int {
+	if xerrors.Is(err, cookieauth.ErrAllHostsSkipped) {
+		return failures
+	}
+	if _, ok := err.(cookieauth.HostErrors); err != nil && !ok {
+		return failures + 1
+	}
+	return 0
+}
+
+// timer is the subset of time.Timer used by refreshLoop.
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realTimer struct{ t *time.Timer }
+
+func newRealTimer(d time.Duration) timer { return realTimer{time.NewTimer(d)} }
+
+func (r realTimer) C() <-chan time.Time { return r.t.C }
+func (r realTimer) Stop() bool          { return r.t.Stop() }
+
+// refreshLoop calls refresh until ctx is done. refresh returns how long to
+// wait before the next call. A reason from force triggers the next call
+// immediately. Each wait uses a fresh timer, so a slow refresh never leaves a
+// stale tick behind.
+func refreshLoop(ctx context.Context, refresh func() time.Duration, force <-chan string, newTimer func(time.Duration) timer) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		t := newTimer(refresh())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case reason := <-force:
+			t.Stop()
+			log.Printf("%s. Refreshing the cookies", reason)
+		case <-t.C():
+		}
+	}
+}
+
+// nextRefresh returns how long to wait before the next refresh given the
+// earliest token expiry. The cookies are refreshed at 80% of the remaining
+// lifetime, or immediately if the tokens have already expired. If the expiry
+// is unknown, it falls back to -refresh-interval.
+func nextRefresh(expiry, now time.Time) time.Duration {
+	if expiry.IsZero() {
+		return *refreshInterval
+	}
+	remaining := expiry.Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+	return remaining * 8 / 10
+}
+
+// clampRefresh returns d limited to [lo, hi]. Zero hi means no limit. This
+// applies after the jitter so that the limits are never exceeded.
+func clampRefresh(d, lo, hi time.Duration) time.Duration {
+	if d < lo {
+		return lo
+	}
+	if hi > 0 && d > hi {
+		return hi
+	}
+	return d
+}
+
+// scheduleRefresh returns the wait before the next refresh. Zero, for the
+// tokens that have already expired, is not clamped so that they are refreshed
+// immediately. It's clamped if the last wait was zero too, so that a clock off
+// never makes the refreshes loop.
+func scheduleRefresh(d, lo, hi time.Duration, refreshedNow bool) time.Duration {
+	if d == 0 && !refreshedNow {
+		return 0
+	}
+	return clampRefresh(d, lo, hi)
+}
+
+// jitter randomizes d by up to pct percent either way, so that the daemons
+// started at the same time do not refresh at the same time.
+func jitter(d time.Duration, pct float64, rnd *rand.Rand) time.Duration {
+	if pct == 0 || d <= 0 {
+		return d
+	}
+	f := 1 + (rnd.Float64()*2-1)*pct/100
+	return time.Duration(float64(d) * f)
+}
+
+// serveHTTP serves h on addr in the background. The returned function shuts
+// down the server.
+func serveHTTP(addr string, h http.Handler) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server on %s stopped: %v", addr, err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/xerrors"
+)
+
+type fakeTimer struct {
+	d       time.Duration
+	c       chan time.Time
+	stopped bool
+}
+
+func (f *fakeTimer) C() <-chan time.Time { return f.c }
+func (f *fakeTimer) Stop() bool {
+	f.stopped = true
+	return true
+}
+
+func TestRefreshLoopFakeTimer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timers := make(chan *fakeTimer)
+	newTimer := func(d time.Duration) timer {
+		f := &fakeTimer{d: d, c: make(chan time.Time, 1)}
+		timers <- f
+		return f
+	}
+	force := make(chan string, 1)
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		refreshLoop(ctx, func() time.Duration {
+			calls++
+			return time.Duration(calls) * time.Minute
+		}, force, newTimer)
+	}()
+
+	// Two ticks, then a forced refresh, then a shutdown.
+	for i := 1; i <= 2; i++ {
+		f := <-timers
+		if want := time.Duration(i) * time.Minute; f.d != want {
+			t.Errorf("timer %d: got %v, want %v", i, f.d, want)
+		}
+		f.c <- time.Now()
+	}
+	f := <-timers
+	force <- "Received hangup"
+	last := <-timers
+	if !f.stopped {
+		t.Errorf("the timer is not stopped after a forced refresh")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshLoop does not return after the context is done")
+	}
+	if !last.stopped {
+		t.Errorf("the timer is not stopped after the context is done")
+	}
+	if calls != 4 {
+		t.Errorf("got %d refreshes, want 4", calls)
+	}
+}
+
+func TestRefreshLoopRealTimer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		interval time.Duration
+		duration time.Duration
+	}{
+		{
+			name:     "fast refresh",
+			interval: 5 * time.Millisecond,
+			duration: 0,
+		},
+		{
+			name:     "slow refresh",
+			interval: time.Millisecond,
+			duration: 20 * time.Millisecond,
+		},
+		{
+			name:     "no wait",
+			interval: 0,
+			duration: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const want = 5
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			calls := 0
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				refreshLoop(ctx, func() time.Duration {
+					time.Sleep(tc.duration)
+					calls++
+					if calls == want {
+						cancel()
+					}
+					return tc.interval
+				}, nil, newRealTimer)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("refreshLoop blocks")
+			}
+			if calls != want {
+				t.Errorf("got %d refreshes, want %d", calls, want)
+			}
+		})
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		expiry time.Time
+		want   time.Duration
+	}{
+		{
+			name: "unknown expiry",
+			want: *refreshInterval,
+		},
+		{
+			name:   "expired",
+			expiry: now.Add(-time.Minute),
+			want:   0,
+		},
+		{
+			name:   "expires now",
+			expiry: now,
+			want:   0,
+		},
+		{
+			name:   "short lifetime",
+			expiry: now.Add(50 * time.Second),
+			want:   40 * time.Second,
+		},
+		{
+			name:   "an hour",
+			expiry: now.Add(time.Hour),
+			want:   48 * time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextRefresh(tc.expiry, now); got != tc.want {
+				t.Errorf("nextRefresh(%v, %v) = %v, want %v", tc.expiry, now, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClampRefresh(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		d      time.Duration
+		lo, hi time.Duration
+		want   time.Duration
+	}{
+		{
+			name: "within",
+			d:    45 * time.Minute,
+			lo:   time.Minute,
+			want: 45 * time.Minute,
+		},
+		{
+			name: "expired token",
+			d:    0,
+			lo:   time.Minute,
+			want: time.Minute,
+		},
+		{
+			name: "long expiry",
+			d:    24 * time.Hour,
+			lo:   time.Minute,
+			hi:   time.Hour,
+			want: time.Hour,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := clampRefresh(tc.d, tc.lo, tc.hi); got != tc.want {
+				t.Errorf("clampRefresh(%v, %v, %v) = %v, want %v", tc.d, tc.lo, tc.hi, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScheduleRefresh(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		d            time.Duration
+		refreshedNow bool
+		want         time.Duration
+	}{
+		{
+			name: "expired token",
+			d:    0,
+			want: 0,
+		},
+		{
+			name:         "expired again",
+			d:            0,
+			refreshedNow: true,
+			want:         time.Minute,
+		},
+		{
+			name: "short lifetime",
+			d:    40 * time.Second,
+			want: time.Minute,
+		},
+		{
+			name: "long expiry",
+			d:    24 * time.Hour,
+			want: time.Hour,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := scheduleRefresh(tc.d, time.Minute, time.Hour, tc.refreshedNow); got != tc.want {
+				t.Errorf("scheduleRefresh(%v, 1m, 1h, %v) = %v, want %v", tc.d, tc.refreshedNow, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCountFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "success",
+			want: 0,
+		},
+		{
+			name: "partial",
+			err:  cookieauth.HostErrors{&cookieauth.HostError{Host: "a.googlesource.com", Err: xerrors.New("failed")}},
+			want: 0,
+		},
+		{
+			name: "failure",
+			err:  xerrors.New("cannot create a token for any host"),
+			want: 3,
+		},
+		{
+			name: "all hosts skipped",
+			err:  &cookieauth.Error{Category: cookieauth.CategoryToken, Err: xerrors.Errorf("%w: [a.googlesource.com]", cookieauth.ErrAllHostsSkipped)},
+			want: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := countFailures(2, tc.err); got != tc.want {
+				t.Errorf("countFailures(2, %v) = %d, want %d", tc.err, got, tc.want)
+			}
+		})
+	}
+}
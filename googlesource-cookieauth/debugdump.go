@@ -0,0 +1,151 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"github.com/google/googlesource-auth-tools/credentials"
+	"golang.org/x/xerrors"
+)
+
+// debugDump is the diagnostic bundle written by -debug-dump for bug reports.
+// It must not have any secrets.
+type debugDump struct {
+	Time       time.Time   `json:"time"`
+	Version    []string    `json:"version"`
+	GitPath    string      `json:"git_path,omitempty"`
+	GitVersion string      `json:"git_version,omitempty"`
+	Configs    []string    `json:"configs"`
+	URLs       []string    `json:"urls"`
+	OutputPath string      `json:"output_path,omitempty"`
+	Hosts      []debugHost `json:"hosts"`
+	// The errors in collecting the above, and the error of the run.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type debugHost struct {
+	Host   string     `json:"host"`
+	Expiry *time.Time `json:"expiry,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// secretConfigWords are the words in the git-config keys whose values are
+// dropped from the dump, such as http.extraHeader.
+var secretConfigWords = []string{"token", "password", "secret", "header", "cookie", "auth", "key"}
+
+// userinfoPattern matches the username and password in the URLs.
+var userinfoPattern = regexp.MustCompile(`://[^/@\s"]+@`)
+
+// redactConfig returns the "-c" parameter with the value dropped if the key
+// may have a secret.
+func redactConfig(kv string) string {
+	i := strings.IndexByte(kv, '=')
+	if i < 0 {
+		return kv
+	}
+	key := strings.ToLower(kv[:i])
+	for _, w := range secretConfigWords {
+		if strings.Contains(key, w) {
+			return kv[:i] + "=REDACTED"
+		}
+	}
+	return kv
+}
+
+// writeDebugDump writes the diagnostic bundle for the result of WriteCookies
+// to path. The token-like strings and the passwords in the URLs are redacted,
+// and so is the token from -token-from-env.
+func writeDebugDump(ctx context.Context, path string, opts *cookieauth.Options, res *cookieauth.Result, runErr error) error {
+	d := &debugDump{
+		Time:    time.Now().UTC(),
+		Configs: []string{},
+		URLs:    []string{},
+		Hosts:   []debugHost{},
+	}
+	var buf bytes.Buffer
+	printVersion(&buf)
+	d.Version = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, c := range opts.Configs {
+		d.Configs = append(d.Configs, redactConfig(c))
+	}
+	if g, err := opts.GitBinary(); err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	} else {
+		d.GitPath = g.Path
+		if v, err := g.Version(ctx); err != nil {
+			d.Errors = append(d.Errors, err.Error())
+		} else {
+			d.GitVersion = v.String()
+		}
+	}
+	if urls, err := cookieauth.ListURLs(ctx, opts); err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	} else {
+		for _, u := range urls {
+			cu := *u
+			cu.User = nil
+			d.URLs = append(d.URLs, cu.String())
+		}
+	}
+	if p, err := cookieauth.OutputPath(ctx, opts); err != nil {
+		d.Errors = append(d.Errors, err.Error())
+	} else {
+		d.OutputPath = p
+	}
+	if res != nil {
+		for _, h := range res.Hosts {
+			dh := debugHost{Host: h}
+			if e, ok := res.HostExpiries[h]; ok {
+				dh.Expiry = &e
+			}
+			d.Hosts = append(d.Hosts, dh)
+		}
+	}
+	var errs cookieauth.HostErrors
+	if xerrors.As(runErr, &errs) {
+		for _, e := range errs {
+			var he *cookieauth.HostError
+			if xerrors.As(e, &he) {
+				d.Hosts = append(d.Hosts, debugHost{Host: he.Host, Error: he.Err.Error()})
+			}
+		}
+	}
+	sort.SliceStable(d.Hosts, func(i, j int) bool { return d.Hosts[i].Host < d.Hosts[j].Host })
+	if runErr != nil {
+		d.Errors = append(d.Errors, runErr.Error())
+	}
+
+	bs, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	bs = []byte(credentials.Redact(userinfoPattern.ReplaceAllString(string(bs), "://REDACTED@")))
+	if *tokenFromEnv != "" {
+		if tok := os.Getenv(*tokenFromEnv); tok != "" {
+			bs = bytes.Replace(bs, []byte(tok), []byte("REDACTED"), -1)
+		}
+	}
+	return ioutil.WriteFile(path, append(bs, '\n'), 0600)
+}
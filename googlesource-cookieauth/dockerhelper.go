@@ -0,0 +1,123 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+)
+
+// dockerUsername is the username that Artifact Registry and Container
+// Registry expect with an access token.
+const dockerUsername = "oauth2accesstoken"
+
+// dockerHelperPrefix is the prefix of the docker credential helper binaries.
+// Docker runs "docker-credential-<name>" for "credHelpers" in its config.
+const dockerHelperPrefix = "docker-credential-"
+
+// isDockerHelperName returns true if the binary is run as a docker credential
+// helper, such as through a symlink named docker-credential-googlesource.
+func isDockerHelperName(arg0 string) bool {
+	return strings.HasPrefix(filepath.Base(arg0), dockerHelperPrefix)
+}
+
+// errDockerCredentialsNotFound is the error that docker takes as no
+// credentials for the server. It must be printed to stdout as is.
+var errDockerCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// isDockerRegistryHost returns true if the host is Artifact Registry or
+// Container Registry. The tokens must not be sent to the other registries,
+// such as docker.io with "credsStore".
+func isDockerRegistryHost(host string) bool {
+	return strings.HasSuffix(host, ".pkg.dev") || host == "gcr.io" || strings.HasSuffix(host, ".gcr.io")
+}
+
+type dockerCredential struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// runDockerCredentialHelper implements the docker credential helper protocol.
+// See https://github.com/docker/docker-credential-helpers for the protocol.
+// Only "get" is supported; "store" and "erase" are no-op, and "list" returns
+// nothing. For the hosts other than Artifact Registry and Container Registry,
+// "get" prints errDockerCredentialsNotFound and returns it.
+func runDockerCredentialHelper(ctx context.Context, opts *cookieauth.Options, op string, r io.Reader, w io.Writer) error {
+	switch op {
+	case "get":
+	case "store", "erase":
+		// The input is the credential to store, or the server URL.
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	case "list":
+		_, err := fmt.Fprintln(w, "{}")
+		return err
+	default:
+		return fmt.Errorf("unknown docker credential helper operation: %s", op)
+	}
+
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("cannot read the server URL: %v", err)
+	}
+	serverURL := strings.TrimSpace(string(bs))
+	if serverURL == "" {
+		return fmt.Errorf("no server URL in the docker credential helper input")
+	}
+	s := serverURL
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("cannot parse the server URL %s: %v", serverURL, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("unknown protocol: %s", u.Scheme)
+	}
+	if !isDockerRegistryHost(u.Hostname()) {
+		fmt.Fprintln(w, errDockerCredentialsNotFound)
+		return errDockerCredentialsNotFound
+	}
+
+	gitBinary, err := opts.GitBinary()
+	if err != nil {
+		return err
+	}
+	token, err := opts.MakeToken(ctx, gitBinary, &url.URL{Scheme: "https", Host: u.Host})
+	if err != nil {
+		return fmt.Errorf("cannot get a token: %v", err)
+	}
+
+	username := opts.Username
+	if username == "" {
+		username = dockerUsername
+	}
+	return json.NewEncoder(w).Encode(&dockerCredential{
+		ServerURL: serverURL,
+		Username:  username,
+		Secret:    token.AccessToken,
+	})
+}
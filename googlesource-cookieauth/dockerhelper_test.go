@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/oauth2"
+)
+
+func TestDockerCredentialHelperGet(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	opts := &cookieauth.Options{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+	var out bytes.Buffer
+	if err := runDockerCredentialHelper(context.Background(), opts, "get", strings.NewReader("us-docker.pkg.dev\n"), &out); err != nil {
+		t.Fatalf("runDockerCredentialHelper: %v", err)
+	}
+	var got dockerCredential
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("cannot parse the output %q: %v", out.String(), err)
+	}
+	want := dockerCredential{ServerURL: "us-docker.pkg.dev", Username: dockerUsername, Secret: "token"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDockerCredentialHelperOtherHost(t *testing.T) {
+	opts := &cookieauth.Options{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+	for _, serverURL := range []string{"https://index.docker.io/v1/", "registry.example.com", "evil-gcr.io", "pkg.dev.example.com"} {
+		t.Run(serverURL, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runDockerCredentialHelper(context.Background(), opts, "get", strings.NewReader(serverURL), &out)
+			if err != errDockerCredentialsNotFound {
+				t.Errorf("runDockerCredentialHelper = %v, want %v", err, errDockerCredentialsNotFound)
+			}
+			if got := strings.TrimSpace(out.String()); got != errDockerCredentialsNotFound.Error() {
+				t.Errorf("output = %q, want %q", got, errDockerCredentialsNotFound)
+			}
+		})
+	}
+}
+
+func TestIsDockerRegistryHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"us-docker.pkg.dev": true,
+		"gcr.io":            true,
+		"eu.gcr.io":         true,
+		"docker.io":         false,
+		"evil-gcr.io":       false,
+		"pkg.dev.evil.com":  false,
+	} {
+		if got := isDockerRegistryHost(host); got != want {
+			t.Errorf("isDockerRegistryHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
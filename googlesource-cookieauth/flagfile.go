@@ -0,0 +1,92 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultFlagFile returns the path to the flag file used if -config is not
+// set, $XDG_CONFIG_HOME/googlesource-cookieauth/config or
+// $HOME/.config/googlesource-cookieauth/config. It returns an empty string if
+// the file doesn't exist.
+func defaultFlagFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	p := filepath.Join(dir, "googlesource-cookieauth", "config")
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// loadFlagFile sets the flags in fs from the file at path. The flags set on
+// the command line take precedence, and the file is ignored for them.
+//
+// Each line is a flag as "name=value", or "name" for a boolean flag. The
+// leading dashes are optional. Blank lines and lines starting with "#" are
+// ignored. A flag that can be specified repeatedly, such as "c" and "host",
+// can be on multiple lines.
+func loadFlagFile(fs *flag.FlagSet, path string) error {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read the config file: %v", err)
+	}
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+	for n, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimLeft(line, "-")
+		name, value := line, ""
+		hasValue := false
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value, hasValue = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]), true
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("%s:%d: unknown flag %s", path, n+1, name)
+		}
+		if name == "config" {
+			return fmt.Errorf("%s:%d: -config cannot be set in the config file", path, n+1)
+		}
+		if !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				return fmt.Errorf("%s:%d: -%s needs a value", path, n+1, name)
+			}
+			value = "true"
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value %q for -%s: %v", path, n+1, value, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadFlagFile(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		args         []string
+		file         string
+		wantInterval time.Duration
+		wantHosts    []string
+		wantDaemon   bool
+		wantErr      bool
+	}{
+		{
+			name:         "file only",
+			file:         "# comment\n-refresh-interval=10m\nhost=a.example.com\nhost = b.example.com\n\nrun-as-daemon\n",
+			wantInterval: 10 * time.Minute,
+			wantHosts:    []string{"a.example.com", "b.example.com"},
+			wantDaemon:   true,
+		},
+		{
+			name:         "command line wins",
+			args:         []string{"-refresh-interval=5m", "-host=c.example.com"},
+			file:         "refresh-interval=10m\nhost=a.example.com\n",
+			wantInterval: 5 * time.Minute,
+			wantHosts:    []string{"c.example.com"},
+		},
+		{
+			name:    "unknown flag",
+			file:    "no-such-flag=1\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			file:    "refresh-interval\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			file:    "refresh-interval=soon\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "flagfile")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tc.file); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			var hosts StringList
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			interval := fs.Duration("refresh-interval", time.Hour, "")
+			daemon := fs.Bool("run-as-daemon", false, "")
+			fs.Var(&hosts, "host", "")
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err = loadFlagFile(fs, f.Name())
+			if tc.wantErr {
+				if err == nil {
+					t.Error("loadFlagFile succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadFlagFile returned %v", err)
+			}
+			if *interval != tc.wantInterval {
+				t.Errorf("-refresh-interval is %v, want %v", *interval, tc.wantInterval)
+			}
+			if !reflect.DeepEqual([]string(hosts), tc.wantHosts) {
+				t.Errorf("-host is %v, want %v", hosts, tc.wantHosts)
+			}
+			if *daemon != tc.wantDaemon {
+				t.Errorf("-run-as-daemon is %v, want %v", *daemon, tc.wantDaemon)
+			}
+		})
+	}
+}
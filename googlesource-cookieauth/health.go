@@ -0,0 +1,63 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthHandler serves /healthz. It returns 200 only if the cookies were
+// written within the staleness window, and with -wait-for-ready, after the
+// first refresh that writes the cookies for all hosts. The hosts skipped by
+// hostBreaker are reported in the body.
+type healthHandler struct {
+	m *metrics
+	// If zero, it's twice the wait scheduled after the last success, so
+	// that it follows the token lifetime.
+	staleness time.Duration
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.m.isWaiting() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "waiting for the first successful refresh")
+		return
+	}
+	last := h.m.lastSuccessTime()
+	if last.IsZero() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "cookies have not been written yet")
+		return
+	}
+	staleness := h.staleness
+	if staleness == 0 {
+		staleness = 2 * h.m.lastSuccessWait()
+	}
+	if age := time.Since(last); age > staleness {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "cookies were last written %v ago, more than %v\n", age.Round(time.Second), staleness)
+		return
+	}
+	if skipped := h.m.skipped(); len(skipped) > 0 {
+		// The other hosts are fine.
+		fmt.Fprintf(w, "ok, skipping %s after consecutive failures\n", strings.Join(skipped, ", "))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+)
+
+func TestHealthHandlerStaleness(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		staleness time.Duration
+		// How long ago the cookies were written.
+		age  time.Duration
+		next time.Duration
+		want int
+	}{
+		{
+			name: "within twice the scheduled wait",
+			age:  50 * time.Minute,
+			next: 48 * time.Minute,
+			want: http.StatusOK,
+		},
+		{
+			name: "after twice the scheduled wait",
+			age:  100 * time.Minute,
+			next: 48 * time.Minute,
+			want: http.StatusServiceUnavailable,
+		},
+		{
+			name:      "explicit staleness",
+			staleness: 10 * time.Minute,
+			age:       20 * time.Minute,
+			next:      48 * time.Minute,
+			want:      http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMetrics()
+			m.observe(time.Second, &cookieauth.Result{}, nil, tc.next)
+			m.lastSuccess = time.Now().Add(-tc.age)
+			rec := httptest.NewRecorder()
+			(&healthHandler{m: m, staleness: tc.staleness}).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != tc.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// openLogFile returns a writer to path for the logs, rotating it when it
+// exceeds maxSizeMB and keeping maxBackups old files.
+func openLogFile(path string, maxSizeMB, maxBackups int) (io.Writer, error) {
+	if maxSizeMB < 1 || maxBackups < 0 {
+		return nil, fmt.Errorf("invalid rotation: max size %d MB, max backups %d", maxSizeMB, maxBackups)
+	}
+	// lumberjack creates the file with 0644, and the rotated files keep the
+	// mode of the current one. Create it first so that nobody else can read
+	// the logs.
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	levelInfo    = "info"
+	levelWarning = "warning"
+	levelError   = "error"
+)
+
+// logFields are the fields of a log event in addition to the level, the time,
+// and the message. They're dropped in the text format.
+type logFields map[string]interface{}
+
+// jsonLogger is set with -log-format=json.
+var jsonLogger *jsonLogWriter
+
+// setLogOutput makes the logger write to w in the format of -log-format.
+func setLogOutput(w io.Writer, format string) error {
+	switch format {
+	case "", "text":
+		log.SetOutput(w)
+	case "json":
+		jsonLogger = &jsonLogWriter{w: w}
+		log.SetFlags(0)
+		log.SetOutput(jsonLogger)
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	return nil
+}
+
+// logEvent logs the message with the fields. Use this instead of log.Printf
+// for the events that the log pipelines look for.
+func logEvent(level string, fields logFields, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if jsonLogger == nil {
+		log.Print(msg)
+		return
+	}
+	jsonLogger.write(level, msg, fields)
+}
+
+// jsonLogWriter writes the log events as JSON lines. A line written by the
+// log package becomes an event of the level guessed from the message.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := levelInfo
+	if strings.HasPrefix(msg, "Cannot ") || strings.HasPrefix(msg, "Invalid flags") || strings.HasPrefix(msg, "Error ") {
+		level = levelError
+	} else if strings.HasPrefix(msg, "Warning: ") {
+		level = levelWarning
+	}
+	if err := j.write(level, msg, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (j *jsonLogWriter) write(level, msg string, fields logFields) error {
+	e := map[string]interface{}{}
+	for k, v := range fields {
+		switch v := v.(type) {
+		case error:
+			e[k] = v.Error()
+		case time.Duration:
+			// In seconds so that the pipelines can compare them.
+			e[k] = v.Seconds()
+		default:
+			e[k] = v
+		}
+	}
+	e["level"] = level
+	e["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	e["msg"] = msg
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(bs, '\n'))
+	return err
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+)
+
+const metricsPrefix = "googlesource_cookieauth_"
+
+// durationBuckets are the upper bounds of the refresh duration histogram in
+// seconds.
+var durationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics exposes the daemon metrics in the Prometheus text format.
+type metrics struct {
+	mu sync.Mutex
+
+	// Number of refreshes by the result: success, partial, or failure.
+	refreshes map[string]uint64
+	// Last time the cookies were written, including a partial success.
+	lastSuccess time.Time
+	// The soonest expiry of the tokens written last time.
+	tokenExpiry time.Time
+	// The wait scheduled after the last time the cookies were written.
+	successWait time.Duration
+
+	// Hosts skipped by hostBreaker, sorted.
+	skippedHosts []string
+
+	// True with -wait-for-ready until the first refresh that writes the
+	// cookies for all hosts.
+	waiting bool
+
+	bucketCounts  []uint64
+	durationSum   float64
+	durationCount uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		refreshes:    map[string]uint64{"success": 0, "partial": 0, "failure": 0},
+		bucketCounts: make([]uint64, len(durationBuckets)),
+	}
+}
+
+// observe records the result of a refresh that took d, and the wait until
+// the next one.
+func (m *metrics) observe(d time.Duration, res *cookieauth.Result, err error, next time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := "success"
+	if _, ok := err.(cookieauth.HostErrors); ok {
+		result = "partial"
+	} else if err != nil {
+		result = "failure"
+	}
+	m.refreshes[result]++
+	if result != "failure" {
+		m.lastSuccess = time.Now()
+		m.tokenExpiry = res.Expiry
+		m.successWait = next
+	}
+
+	sec := d.Seconds()
+	for i, b := range durationBuckets {
+		if sec <= b {
+			m.bucketCounts[i]++
+		}
+	}
+	m.durationSum += sec
+	m.durationCount++
+}
+
+// setSkippedHosts records the hosts skipped by hostBreaker.
+func (m *metrics) setSkippedHosts(hosts []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skippedHosts = hosts
+}
+
+// skipped returns the hosts skipped by hostBreaker.
+func (m *metrics) skipped() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.skippedHosts
+}
+
+// setWaiting sets whether the daemon is waiting for the first successful
+// refresh.
+func (m *metrics) setWaiting(waiting bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.waiting = waiting
+}
+
+// isWaiting returns true if the daemon is waiting for the first successful
+// refresh.
+func (m *metrics) isWaiting() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.waiting
+}
+
+// lastSuccessWait returns the wait scheduled after the last time the cookies
+// were written.
+func (m *metrics) lastSuccessWait() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.successWait
+}
+
+// lastSuccessTime returns the last time the cookies were written.
+func (m *metrics) lastSuccessTime() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSuccess
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP %srefreshes_total Number of cookie refreshes by the result.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %srefreshes_total counter\n", metricsPrefix)
+	for _, result := range []string{"success", "partial", "failure"} {
+		fmt.Fprintf(w, "%srefreshes_total{result=%q} %d\n", metricsPrefix, result, m.refreshes[result])
+	}
+
+	fmt.Fprintf(w, "# HELP %slast_success_timestamp_seconds Last time the cookies were written.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %slast_success_timestamp_seconds gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%slast_success_timestamp_seconds %d\n", metricsPrefix, unixOrZero(m.lastSuccess))
+
+	fmt.Fprintf(w, "# HELP %stoken_expiry_timestamp_seconds The soonest expiry of the written tokens.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %stoken_expiry_timestamp_seconds gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%stoken_expiry_timestamp_seconds %d\n", metricsPrefix, unixOrZero(m.tokenExpiry))
+
+	fmt.Fprintf(w, "# HELP %sskipped_host Hosts skipped after consecutive failures.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sskipped_host gauge\n", metricsPrefix)
+	for _, h := range m.skippedHosts {
+		fmt.Fprintf(w, "%sskipped_host{host=%q} 1\n", metricsPrefix, h)
+	}
+
+	fmt.Fprintf(w, "# HELP %srefresh_duration_seconds Duration of the cookie refreshes.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %srefresh_duration_seconds histogram\n", metricsPrefix)
+	for i, b := range durationBuckets {
+		fmt.Fprintf(w, "%srefresh_duration_seconds_bucket{le=\"%g\"} %d\n", metricsPrefix, b, m.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "%srefresh_duration_seconds_bucket{le=\"+Inf\"} %d\n", metricsPrefix, m.durationCount)
+	fmt.Fprintf(w, "%srefresh_duration_seconds_sum %g\n", metricsPrefix, m.durationSum)
+	fmt.Fprintf(w, "%srefresh_duration_seconds_count %d\n", metricsPrefix, m.durationCount)
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
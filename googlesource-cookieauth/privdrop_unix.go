@@ -0,0 +1,71 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to the user, a name or a numeric UID,
+// with its primary and supplementary groups. It cannot be undone.
+func dropPrivileges(name string) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, nerr := strconv.Atoi(name); nerr == nil {
+			u, err = user.LookupId(name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot find the user %s: %v", name, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid UID %s for %s", u.Uid, name)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid GID %s for %s", u.Gid, name)
+	}
+	gids := []int{gid}
+	if ss, err := u.GroupIds(); err == nil {
+		for _, s := range ss {
+			if g, err := strconv.Atoi(s); err == nil && g != gid {
+				gids = append(gids, g)
+			}
+		}
+	}
+	// The groups first, because they cannot be changed after setuid.
+	if err := syscall.Setgroups(gids); err != nil {
+		return fmt.Errorf("cannot set the supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("cannot set the GID to %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("cannot set the UID to %d: %v", uid, err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("the root privileges can be regained after setuid")
+	}
+	log.Printf("Dropped the privileges to %s (UID %d, GID %d)", u.Username, uid, gid)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"github.com/google/googlesource-auth-tools/credentials"
+)
+
+// daemonStatus is the content of -status-file. It must not have the tokens.
+type daemonStatus struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	// Nil until the first refresh that writes the cookies.
+	LastSuccess *time.Time `json:"last_success"`
+	// The error of the last refresh that failed, even partially.
+	LastError   *statusError `json:"last_error"`
+	NextRefresh time.Time    `json:"next_refresh"`
+	// The token expiry of each host written in the last successful refresh.
+	// The hosts whose tokens have no expiry are not included.
+	Hosts map[string]time.Time `json:"hosts"`
+}
+
+type statusError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// record updates the status with the result of a refresh.
+func (s *daemonStatus) record(res *cookieauth.Result, err error, now time.Time, next time.Duration) {
+	now = now.UTC()
+	s.UpdatedAt = now
+	s.NextRefresh = now.Add(next)
+	if res != nil && !res.Skipped {
+		s.LastSuccess = &now
+		s.Hosts = map[string]time.Time{}
+		for h, e := range res.HostExpiries {
+			s.Hosts[h] = e.UTC()
+		}
+	}
+	if err != nil {
+		s.LastError = &statusError{Time: now, Error: credentials.Redact(err.Error())}
+	}
+	if s.Hosts == nil {
+		s.Hosts = map[string]time.Time{}
+	}
+}
+
+// writeStatusFile replaces the file at path with the status atomically, so
+// that the readers never see a partial file.
+func writeStatusFile(path string, s *daemonStatus) (err error) {
+	bs, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// ioutil.TempFile creates the file with 0600.
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
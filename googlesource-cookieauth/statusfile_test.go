@@ -0,0 +1,90 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/googlesource-auth-tools/cookieauth"
+	"golang.org/x/xerrors"
+)
+
+func TestDaemonStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statusfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "status.json")
+
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(time.Hour)
+	s := &daemonStatus{}
+	s.record(&cookieauth.Result{Hosts: []string{"a.googlesource.com"}, HostExpiries: map[string]time.Time{"a.googlesource.com": expiry}}, nil, now, 45*time.Minute)
+	// A failed refresh keeps the last success and the expiries.
+	later := now.Add(45 * time.Minute)
+	s.record(nil, xerrors.New("cannot create a token for ya29.secret"), later, time.Minute)
+	if err := writeStatusFile(path, s); err != nil {
+		t.Fatalf("writeStatusFile: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "ya29.secret") {
+		t.Errorf("the status file has the token: %s", bs)
+	}
+	var got struct {
+		LastSuccess time.Time `json:"last_success"`
+		LastError   struct {
+			Time time.Time `json:"time"`
+		} `json:"last_error"`
+		NextRefresh time.Time            `json:"next_refresh"`
+		Hosts       map[string]time.Time `json:"hosts"`
+	}
+	if err := json.Unmarshal(bs, &got); err != nil {
+		t.Fatalf("cannot parse the status file: %v", err)
+	}
+	if !got.LastSuccess.Equal(now) {
+		t.Errorf("last_success = %v, want %v", got.LastSuccess, now)
+	}
+	if !got.LastError.Time.Equal(later) {
+		t.Errorf("last_error.time = %v, want %v", got.LastError.Time, later)
+	}
+	if want := later.Add(time.Minute); !got.NextRefresh.Equal(want) {
+		t.Errorf("next_refresh = %v, want %v", got.NextRefresh, want)
+	}
+	if e, ok := got.Hosts["a.googlesource.com"]; !ok || !e.Equal(expiry) || len(got.Hosts) != 1 {
+		t.Errorf("hosts = %v, want a.googlesource.com at %v", got.Hosts, expiry)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("the temporary file is left: %d files", len(files))
+	}
+}
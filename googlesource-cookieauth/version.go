@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// These can be set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// If not set, they are taken from the build info embedded by the go command.
+var (
+	version   string
+	revision  string
+	buildTime string
+)
+
+// printVersion prints the version, the VCS revision, and the build time.
+func printVersion(w io.Writer) {
+	v, rev, t := version, revision, buildTime
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if v == "" {
+			v = bi.Main.Version
+		}
+		settings := map[string]string{}
+		for _, s := range bi.Settings {
+			settings[s.Key] = s.Value
+		}
+		if rev == "" && settings["vcs.revision"] != "" {
+			rev = settings["vcs.revision"]
+			if settings["vcs.modified"] == "true" {
+				rev += "-dirty"
+			}
+		}
+		if t == "" {
+			t = settings["vcs.time"]
+		}
+	}
+	fmt.Fprintf(w, "googlesource-cookieauth %s\n", orUnknown(v))
+	fmt.Fprintf(w, "revision: %s\n", orUnknown(rev))
+	fmt.Fprintf(w, "build time: %s\n", orUnknown(t))
+	fmt.Fprintf(w, "go: %s\n", runtime.Version())
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/googlesource-auth-tools/credentials"
+)
+
+// configDebounce is how long to wait after a git-config file changes before
+// refreshing, so that the successive writes by an editor cause one refresh.
+const configDebounce = time.Second
+
+// configWatcher watches the git-config files.
+//
+// The directories of the files are watched instead of the files themselves.
+// Many editors write a new file and rename it over the old one, and a watch on
+// the old file would be lost.
+type configWatcher struct {
+	gitBinary credentials.GitBinary
+	w         *fsnotify.Watcher
+	// Absolute paths of the git-config files.
+	files map[string]bool
+	// Watched directories.
+	dirs map[string]bool
+}
+
+func newConfigWatcher(ctx context.Context, gitBinary credentials.GitBinary) (*configWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	cw := &configWatcher{
+		gitBinary: gitBinary,
+		w:         w,
+		files:     map[string]bool{},
+		dirs:      map[string]bool{},
+	}
+	if err := cw.update(ctx); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return cw, nil
+}
+
+// update re-reads the list of the git-config files and watches their
+// directories. An include may have been added or removed.
+func (cw *configWatcher) update(ctx context.Context) error {
+	files, err := cw.gitBinary.ConfigFiles(ctx)
+	if err != nil {
+		return err
+	}
+	cw.files = map[string]bool{}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		cw.files[f] = true
+		dirs[filepath.Dir(f)] = true
+	}
+	for d := range dirs {
+		if cw.dirs[d] {
+			continue
+		}
+		if err := cw.w.Add(d); err != nil {
+			return fmt.Errorf("cannot watch %s: %v", d, err)
+		}
+		cw.dirs[d] = true
+	}
+	for d := range cw.dirs {
+		if !dirs[d] {
+			cw.w.Remove(d)
+			delete(cw.dirs, d)
+		}
+	}
+	return nil
+}
+
+// run sends the changed file to changed until ctx is done. The changes within
+// configDebounce are coalesced, and a change is dropped if the previous one
+// is not received yet.
+func (cw *configWatcher) run(ctx context.Context, changed chan<- string) {
+	defer cw.w.Close()
+	var debounce <-chan time.Time
+	var name string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-cw.w.Events:
+			if !ok {
+				return
+			}
+			if cw.files[filepath.Clean(ev.Name)] && debounce == nil {
+				debounce = time.After(configDebounce)
+				name = ev.Name
+			}
+		case err, ok := <-cw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Error while watching the git-config files: %v", err)
+		case <-debounce:
+			debounce = nil
+			if err := cw.update(ctx); err != nil {
+				log.Printf("Cannot update the watched git-config files: %v", err)
+			}
+			select {
+			case changed <- fmt.Sprintf("%s changed", name):
+			default:
+			}
+		}
+	}
+}